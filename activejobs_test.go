@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/go-redis/redis/v8"
+)
+
+func TestCountActiveJobs(t *testing.T) {
+	e := setupTest(t, 1, nil)
+	e.setWorkers(t, 2500, 1200)
+	jobs, err := countActiveJobs(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if jobs != 1200 {
+		t.Errorf("countActiveJobs() = %d, want 1200", jobs)
+	}
+}
+
+func TestCountActiveJobsFailedBatch(t *testing.T) {
+	e := setupTest(t, 1, nil)
+	e.setWorkers(t, 10, 5)
+	e.redis.SetError("ERR injected")
+	if jobs, err := countActiveJobs(context.Background()); err == nil {
+		t.Errorf("countActiveJobs() = %d, nil with redis failing, want an error", jobs)
+	}
+}
+
+// perWorkerActiveJobs counts active jobs the way countActiveJobs used to,
+// with a GET per worker, for comparison.
+func perWorkerActiveJobs(ctx context.Context) (int, error) {
+	workers, err := autoscaler.redis.SMembers(context.Background(), "resque:workers").Result()
+	if err != nil {
+		return 0, err
+	}
+	jobs := 0
+	for _, worker := range workers {
+		err := autoscaler.redis.Get(context.Background(), fmt.Sprintf("resque:worker:%s", worker)).Err()
+		if err == nil {
+			jobs++
+		} else if err != redis.Nil {
+			return 0, err
+		}
+	}
+	return jobs, nil
+}
+
+// BenchmarkCountActiveJobs counts the jobs of 2,000 workers, half of them
+// busy, against miniredis. Batching the EXISTS calls took it from a GET per
+// worker to three round trips on a single core linux/amd64 VM:
+//
+//	BenchmarkCountActiveJobs/batched       871	  1435664 ns/op	 614659 B/op	 16070 allocs/op
+//	BenchmarkCountActiveJobs/per-worker     52	 21194817 ns/op	1174593 B/op	 44018 allocs/op
+//
+// Most of the allocations are miniredis's own. A round trip to a real Redis
+// costs far more than one to miniredis, so the difference is larger still.
+func BenchmarkCountActiveJobs(b *testing.B) {
+	e := setupTest(b, 1, nil)
+	e.setWorkers(b, 2000, 1000)
+	b.Run("batched", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := countActiveJobs(context.Background()); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("per-worker", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := perWorkerActiveJobs(context.Background()); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
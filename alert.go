@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var alertClient = &http.Client{Timeout: 10 * time.Second}
+
+// sendAlert logs msg at error level and, if an alert webhook is configured,
+// posts it there in the background, prefixed with the environment. Delivery
+// is best effort.
+func sendAlert(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	log.Error(msg)
+	url := autoscaler.config().AlertWebhookURL
+	if url == "" {
+		return
+	}
+	env := autoscaler.config().Environment
+	text := msg
+	if env != "" {
+		text = fmt.Sprintf("[%s] %s", env, msg)
+	} else {
+		env = "unknown"
+	}
+	payload, err := json.Marshal(map[string]string{"text": text, "environment": env})
+	if err != nil {
+		log.Errorf("failed to encode alert: %v", err)
+		return
+	}
+	go func() {
+		res, err := alertClient.Post(url, "application/json", bytes.NewReader(payload))
+		if err != nil {
+			log.Errorf("failed to send alert: %v", err)
+			return
+		}
+		res.Body.Close()
+		if res.StatusCode >= http.StatusBadRequest {
+			log.Errorf("failed to send alert: webhook returned status %d", res.StatusCode)
+		}
+	}()
+}
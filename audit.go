@@ -0,0 +1,29 @@
+package main
+
+import (
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// writeAuditEntry appends a record of a successful scale to the audit
+// stream. It is best effort: failures are logged but never block scaling.
+func writeAuditEntry(d scaleDecision) {
+	if autoscaler.config().AuditStream == "" || autoscaler.redis == nil {
+		return
+	}
+	err := autoscaler.redis.XAdd(autoscaler.ctx, &redis.XAddArgs{
+		Stream: autoscaler.config().AuditStream,
+		Values: map[string]interface{}{
+			"timestamp": time.Now().UTC().Format(time.RFC3339),
+			"service":   autoscaler.config().WorkerServiceId,
+			"from":      d.From,
+			"to":        d.To,
+			"backlog":   d.Backlog,
+			"reason":    d.Reason,
+		},
+	}).Err()
+	if err != nil {
+		recordError("redis", "failed to write scale to audit stream: %v", err)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	log "github.com/sirupsen/logrus"
+)
+
+// bounds are the scaling parameters that can be changed at runtime through
+// the admin API. Durations use time.ParseDuration syntax.
+type bounds struct {
+	MinInstances   *int    `json:"minInstances,omitempty"`
+	MaxInstances   *int    `json:"maxInstances,omitempty"`
+	ScaleUpDelay   *string `json:"scaleUpDelay,omitempty"`
+	ScaleDownDelay *string `json:"scaleDownDelay,omitempty"`
+}
+
+// currentBounds must be called with the state mutex held.
+func currentBounds() bounds {
+	min, max := autoscaler.config().MinInstances, autoscaler.config().MaxInstances
+	up, down := autoscaler.config().ScaleUpDelay.String(), autoscaler.config().ScaleDownDelay.String()
+	return bounds{MinInstances: &min, MaxInstances: &max, ScaleUpDelay: &up, ScaleDownDelay: &down}
+}
+
+// applyBounds validates b and copies any fields it sets into the config. It
+// must be called with the state mutex held.
+func applyBounds(b bounds) error {
+	config := *autoscaler.config()
+	if b.MinInstances != nil {
+		config.MinInstances = *b.MinInstances
+	}
+	if b.MaxInstances != nil {
+		config.MaxInstances = *b.MaxInstances
+	}
+	if b.ScaleUpDelay != nil {
+		d, err := time.ParseDuration(*b.ScaleUpDelay)
+		if err != nil {
+			return fmt.Errorf("invalid scaleUpDelay: %v", err)
+		}
+		config.ScaleUpDelay = d
+	}
+	if b.ScaleDownDelay != nil {
+		d, err := time.ParseDuration(*b.ScaleDownDelay)
+		if err != nil {
+			return fmt.Errorf("invalid scaleDownDelay: %v", err)
+		}
+		config.ScaleDownDelay = d
+	}
+	if config.MinInstances < 0 || config.MaxInstances < config.MinInstances {
+		return fmt.Errorf("invalid bounds: min %d, max %d", config.MinInstances, config.MaxInstances)
+	}
+	autoscaler.setConfig(config)
+	return nil
+}
+
+// persistedBounds returns the bounds persisted through the admin API, if any,
+// along with their JSON encoding.
+func persistedBounds() (*bounds, string) {
+	if autoscaler.redis == nil {
+		return nil, ""
+	}
+	val, err := autoscaler.redis.Get(autoscaler.ctx, autoscaler.config().BoundsKey).Result()
+	if err == redis.Nil {
+		return nil, ""
+	}
+	if err != nil {
+		recordError("redis", "failed to read persisted bounds from redis: %v", err)
+		return nil, ""
+	}
+	var b bounds
+	if err := json.Unmarshal([]byte(val), &b); err != nil {
+		recordError("parse", "failed to decode persisted bounds: %v", err)
+		return nil, ""
+	}
+	return &b, val
+}
+
+// loadBounds applies bounds persisted by a previous run, if any.
+func loadBounds() {
+	b, val := persistedBounds()
+	if b == nil {
+		return
+	}
+	autoscaler.mu.Lock()
+	defer autoscaler.mu.Unlock()
+	if err := applyBounds(*b); err != nil {
+		log.Errorf("ignoring persisted bounds: %v", err)
+		return
+	}
+	log.Infof("loaded persisted bounds: %s", val)
+}
+
+func handleBounds(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		autoscaler.mu.Lock()
+		b := currentBounds()
+		autoscaler.mu.Unlock()
+		writeJSON(w, b)
+		return
+	}
+
+	var b bounds
+	if err := json.NewDecoder(r.Body).Decode(&b); err != nil {
+		http.Error(w, "invalid json body", http.StatusBadRequest)
+		return
+	}
+	autoscaler.mu.Lock()
+	old := currentBounds()
+	err := applyBounds(b)
+	updated := currentBounds()
+	autoscaler.mu.Unlock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	oldJSON, _ := json.Marshal(old)
+	newJSON, _ := json.Marshal(updated)
+	log.Infof("bounds changed over http by %s from %s to %s", r.RemoteAddr, oldJSON, newJSON)
+	if autoscaler.redis != nil {
+		if err := autoscaler.redis.Set(autoscaler.ctx, autoscaler.config().BoundsKey, newJSON, 0).Err(); err != nil {
+			recordError("redis", "failed to persist bounds to redis: %v", err)
+		}
+	}
+	writeJSON(w, updated)
+}
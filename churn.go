@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// refreshChurn compares the resque worker sets of all namespaces with the
+// previous iteration's and records workers that disappeared within WorkerChurnWindow of first
+// being seen. Short-lived workers like that usually mean instances are crash
+// looping. It must not be called with the state mutex held.
+func refreshChurn(ctx context.Context) {
+	if autoscaler.config().WorkerChurnThreshold <= 0 || autoscaler.redis == nil {
+		return
+	}
+	registered, err := registeredWorkers(ctx)
+	if err != nil {
+		recordError("redis", "failed to retrieve resque worker set from redis: %v", err)
+		return
+	}
+	now := time.Now()
+	window := autoscaler.config().WorkerChurnWindow
+
+	autoscaler.mu.Lock()
+	defer autoscaler.mu.Unlock()
+	current := make(map[string]time.Time)
+	for namespace, workers := range registered {
+		for _, worker := range workers {
+			worker = namespacedKey(namespace, worker)
+			firstSeen, ok := autoscaler.workersSeen[worker]
+			if !ok {
+				firstSeen = now
+			}
+			current[worker] = firstSeen
+		}
+	}
+	if autoscaler.workersSeen != nil {
+		for worker, firstSeen := range autoscaler.workersSeen {
+			if _, ok := current[worker]; !ok && now.Sub(firstSeen) < window {
+				autoscaler.departures = append(autoscaler.departures, now)
+			}
+		}
+	} else {
+		// workers registered before startup are of unknown age, so they
+		// never count as short-lived
+		for worker := range current {
+			current[worker] = time.Time{}
+		}
+	}
+	autoscaler.workersSeen = current
+
+	i := 0
+	for i < len(autoscaler.departures) && now.Sub(autoscaler.departures[i]) >= window {
+		i++
+	}
+	autoscaler.departures = autoscaler.departures[i:]
+	autoscaler.churnRate = float64(len(autoscaler.departures)) / window.Minutes()
+	workerChurnRate.Set(autoscaler.churnRate)
+}
+
+// stableEnoughToScaleUp reports whether worker churn is below the threshold.
+// It alerts when that stops being the case and must be called with the state
+// mutex held.
+func stableEnoughToScaleUp() bool {
+	threshold := autoscaler.config().WorkerChurnThreshold
+	churning := threshold > 0 && autoscaler.churnRate > threshold
+	if churning && !autoscaler.churning && !autoscaler.probing {
+		sendAlert("%.1f short-lived workers per minute suggests instances are crash looping, suppressing scale-ups",
+			autoscaler.churnRate)
+	} else if !churning && autoscaler.churning {
+		log.Info("worker churn subsided, resuming scale-ups")
+	}
+	autoscaler.churning = churning
+	return !churning
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestRefreshChurnNamespaces(t *testing.T) {
+	e := setupTest(t, 2, map[string]string{
+		"WORKER_CHURN_THRESHOLD": "1",
+		"RESQUE_NAMESPACES":      "app1,app2",
+	})
+	refreshChurn(context.Background())
+
+	// a worker that registers in the second namespace and disappears soon after
+	if _, err := e.redis.SetAdd("app2:workers", "host:1:default"); err != nil {
+		t.Fatal(err)
+	}
+	refreshChurn(context.Background())
+	if _, err := e.redis.SRem("app2:workers", "host:1:default"); err != nil {
+		t.Fatal(err)
+	}
+	refreshChurn(context.Background())
+
+	if got := len(autoscaler.departures); got != 1 {
+		t.Errorf("%d short-lived workers recorded, want the 1 in the second namespace", got)
+	}
+}
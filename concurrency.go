@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/tidwall/gjson"
+)
+
+// getServiceEnvVar returns the value of an environment variable configured on
+// the worker service, following the cursor through every page.
+func getServiceEnvVar(ctx context.Context, key string) (string, error) {
+	cursor := ""
+	for {
+		path := fmt.Sprintf("/services/%s/env-vars?limit=100", autoscaler.config().WorkerServiceId)
+		if cursor != "" {
+			path += "&cursor=" + url.QueryEscape(cursor)
+		}
+		status, resp, err := renderAPICall(ctx, workerServiceAPIKey(), "GET", path, "")
+		if err != nil {
+			return "", err
+		}
+		if status != http.StatusOK {
+			return "", fmt.Errorf("unexpected status %d", status)
+		}
+		items := gjson.Parse(resp).Array()
+		for _, item := range items {
+			if item.Get("envVar.key").String() == key {
+				return item.Get("envVar.value").String(), nil
+			}
+		}
+		if len(items) < 100 || items[len(items)-1].Get("cursor").String() == "" {
+			return "", fmt.Errorf("service has no %s environment variable", key)
+		}
+		cursor = items[len(items)-1].Get("cursor").String()
+	}
+}
+
+// workersPerInstanceLoop keeps the workers per instance in sync with the
+// worker concurrency configured in the worker service's WorkersEnvVar.
+// While the value can't be read, the static WORKERS_PER_INSTANCE is used.
+func workersPerInstanceLoop(static int) {
+	key := autoscaler.config().WorkersEnvVar
+	current := static
+	for {
+		workers := static
+		value, err := getServiceEnvVar(autoscaler.ctx, key)
+		if err == nil {
+			n, convErr := strconv.Atoi(value)
+			if convErr != nil || n <= 0 {
+				err = fmt.Errorf("%s is not a positive integer: %q", key, value)
+			} else {
+				workers = n
+			}
+		}
+		if err != nil {
+			recordError("render", "failed to read workers per instance from the worker service: %v", err)
+		}
+
+		if workers != current {
+			log.Infof("workers per instance changed from %d to %d", current, workers)
+			current = workers
+		}
+		atomic.StoreInt64(&autoscaler.syncedWorkers, int64(workers))
+		time.Sleep(autoscaler.config().WorkersRefreshInterval)
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// secretConfigFields are masked when the config is logged or served.
+var secretConfigFields = map[string]bool{
+	"RenderAPIKey":        true,
+	"WorkerServiceAPIKey": true,
+	"AdminSecret":         true,
+	"AlertWebhookURL":     true,
+}
+
+// secretValueConfigFields are maps whose keys are shown when the config is
+// logged or served, but whose values are masked.
+var secretValueConfigFields = map[string]bool{
+	"RenderHeaders": true,
+}
+
+// reloadableConfigFields can be changed by a reload. Changes to any other
+// field are ignored until a restart.
+var reloadableConfigFields = map[string]bool{
+	"LogLevel":               true,
+	"RenderAPIKey":           true,
+	"WorkerServiceAPIKey":    true,
+	"MinInstances":           true,
+	"MaxInstances":           true,
+	"Interval":               true,
+	"MinInterval":            true,
+	"MaxInterval":            true,
+	"PredictionHorizon":      true,
+	"ScaleUpDelay":           true,
+	"ScaleDownDelay":         true,
+	"FirstScaleUpDelay":      true,
+	"WarmupPeriod":           true,
+	"IdleJobThreshold":       true,
+	"GrowthBoostFactor":      true,
+	"GrowthBoostSamples":     true,
+	"GrowthThreshold":        true,
+	"QueueRatios":            true,
+	"MaxConsecutiveScaleUps": true,
+	"MinHealthyRatio":        true,
+	"BacklogBaseline":        true,
+	"BaseInstances":          true,
+	"QueueLatencySLO":        true,
+	"CPUTarget":              true,
+	"AvgJobDuration":         true,
+	"DrainTimeTarget":        true,
+	"DynamicDelay":           true,
+	"DynamicDelayMinBacklog": true,
+	"DynamicDelayMaxBacklog": true,
+	"WorkerChurnThreshold":   true,
+	"MaxBacklog":             true,
+}
+
+// readConfigFile returns the values in CONFIG_FILE, if set. The file has one
+// KEY=value pair per line; blank lines and lines starting with # are
+// skipped.
+func readConfigFile() (map[string]string, error) {
+	path := os.Getenv("CONFIG_FILE")
+	if path == "" {
+		return nil, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read config file: %v", err)
+	}
+	defer f.Close()
+	values := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(text, "=")
+		if !ok {
+			return nil, fmt.Errorf("config file line %d is not KEY=value", line)
+		}
+		values[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	return values, scanner.Err()
+}
+
+// overlayConfigFile sets the values from CONFIG_FILE over the process
+// environment, so that they take precedence while the config is loaded, and
+// returns a function that puts the environment back. The file only ever
+// applies for the duration of a load, so a key removed from it stops
+// applying on the next reload.
+func overlayConfigFile() (restore func(), err error) {
+	values, err := readConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	type previous struct {
+		value string
+		set   bool
+	}
+	saved := make(map[string]previous, len(values))
+	for key, value := range values {
+		prev, set := os.LookupEnv(key)
+		saved[key] = previous{prev, set}
+		os.Setenv(key, value)
+	}
+	return func() {
+		for key, prev := range saved {
+			if prev.set {
+				os.Setenv(key, prev.value)
+			} else {
+				os.Unsetenv(key)
+			}
+		}
+	}, nil
+}
+
+// reloadOnSIGHUP reloads the config whenever the process receives SIGHUP.
+func reloadOnSIGHUP() {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGHUP)
+	for range c {
+		reloadConfig()
+	}
+}
+
+// reloadConfig re-reads and validates the config and swaps in the values of
+// the reloadable fields. An invalid config is rejected as a whole. Bounds
+// persisted through the admin API still take precedence over the reloaded
+// ones.
+func reloadConfig() {
+	config, err := loadConfig()
+	if err != nil {
+		recordError("parse", "not reloading invalid config: %v", err)
+		return
+	}
+	persisted, _ := persistedBounds()
+
+	autoscaler.mu.Lock()
+	defer autoscaler.mu.Unlock()
+	reloaded := *autoscaler.config()
+	current := reflect.ValueOf(&reloaded).Elem()
+	updated := reflect.ValueOf(config)
+	var changed []string
+	for i := 0; i < current.NumField(); i++ {
+		name := current.Type().Field(i).Name
+		if reflect.DeepEqual(current.Field(i).Interface(), updated.Field(i).Interface()) {
+			continue
+		}
+		if name == "WorkersPerInstance" && reloaded.WorkersEnvVar != "" {
+			// kept in sync with the worker service instead
+			continue
+		}
+		if !reloadableConfigFields[name] {
+			log.Warnf("ignoring change to %s, which requires a restart", name)
+			continue
+		}
+		current.Field(i).Set(updated.Field(i))
+		changed = append(changed, name)
+	}
+	autoscaler.setConfig(reloaded)
+	if persisted != nil {
+		if err := applyBounds(*persisted); err != nil {
+			log.Errorf("ignoring persisted bounds: %v", err)
+		}
+	}
+	log.SetLevel(reloaded.LogLevel)
+	if len(changed) == 0 {
+		log.Info("reloaded config, nothing changed")
+		return
+	}
+	log.WithFields(configFields(*autoscaler.config())).Infof("reloaded config, changed %s", strings.Join(changed, ", "))
+}
+
+// configFields returns every config value by field name, with secrets masked
+// if set.
+func configFields(config AutoscalerConfig) log.Fields {
+	fields := log.Fields{}
+	v := reflect.ValueOf(config)
+	for i := 0; i < v.NumField(); i++ {
+		name := v.Type().Field(i).Name
+		value := v.Field(i)
+		switch {
+		case secretConfigFields[name]:
+			if !value.IsZero() {
+				fields[name] = "********"
+			}
+			continue
+		case secretValueConfigFields[name]:
+			masked := make(map[string]string, value.Len())
+			for _, key := range value.MapKeys() {
+				masked[key.String()] = "********"
+			}
+			fields[name] = masked
+			continue
+		case value.Kind() == reflect.Ptr:
+			if value.IsNil() {
+				continue
+			}
+			value = value.Elem()
+		}
+		if s, ok := value.Interface().(fmt.Stringer); ok {
+			fields[name] = s.String()
+		} else {
+			fields[name] = value.Interface()
+		}
+	}
+	return fields
+}
+
+// handleConfig returns the config in effect, with secrets masked.
+func handleConfig(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, configFields(*autoscaler.config()))
+}
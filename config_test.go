@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestConfigFieldsMasksSecrets(t *testing.T) {
+	fields := configFields(AutoscalerConfig{
+		RenderAPIKey:  "rnd_secret",
+		RenderHeaders: map[string]string{"X-Proxy-Token": "abc123"},
+	})
+	if got := fmt.Sprint(fields); strings.Contains(got, "rnd_secret") || strings.Contains(got, "abc123") {
+		t.Errorf("configFields() = %s, want secrets masked", got)
+	}
+	headers, ok := fields["RenderHeaders"].(map[string]string)
+	if !ok || headers["X-Proxy-Token"] != "********" {
+		t.Errorf("RenderHeaders = %v, want the header name with its value masked", fields["RenderHeaders"])
+	}
+}
+
+func TestReloadKeepsPersistedBounds(t *testing.T) {
+	setupTest(t, 2, map[string]string{"MAX_INSTANCES": "10", "IDLE_JOB_THRESHOLD": "0"})
+	req := httptest.NewRequest("POST", "/bounds", strings.NewReader(`{"maxInstances": 20}`))
+	rec := httptest.NewRecorder()
+	handleBounds(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("POST /bounds: status %d: %s", rec.Code, rec.Body)
+	}
+
+	t.Setenv("MAX_INSTANCES", "30")
+	t.Setenv("IDLE_JOB_THRESHOLD", "5")
+	reloadConfig()
+	if got := autoscaler.config().MaxInstances; got != 20 {
+		t.Errorf("MaxInstances = %d after a reload, want the persisted 20", got)
+	}
+	if got := autoscaler.config().IdleJobThreshold; got != 5 {
+		t.Errorf("IdleJobThreshold = %d after a reload, want the reloaded 5", got)
+	}
+}
+
+func TestReloadIsSafeWithConcurrentReaders(t *testing.T) {
+	setupTest(t, 2, map[string]string{"MAX_BACKLOG": "100"})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			workerServiceAPIKey()
+			clampBacklog(50)
+		}
+	}()
+	for i := 0; i < 10; i++ {
+		t.Setenv("RENDER_API_KEY", fmt.Sprintf("key-%d", i))
+		reloadConfig()
+	}
+	<-done
+	if got := workerServiceAPIKey(); got != "key-9" {
+		t.Errorf("workerServiceAPIKey() = %q, want the reloaded key-9", got)
+	}
+}
+
+func TestConfigFileKeyRemovedOnReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "autoscaler.env")
+	if err := os.WriteFile(path, []byte("MIN_INSTANCES=3\nMAX_INSTANCES=7\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	setupTest(t, 3, map[string]string{"CONFIG_FILE": path, "MIN_INSTANCES": "1"})
+	if min, max := autoscaler.config().MinInstances, autoscaler.config().MaxInstances; min != 3 || max != 7 {
+		t.Fatalf("bounds = %d-%d, want the 3-7 from the config file", min, max)
+	}
+	if got := os.Getenv("MIN_INSTANCES"); got != "1" {
+		t.Errorf("MIN_INSTANCES = %q in the environment after loading, want the unchanged 1", got)
+	}
+	if _, set := os.LookupEnv("MAX_INSTANCES"); set {
+		t.Error("MAX_INSTANCES is set in the environment after loading, want it left unset")
+	}
+
+	if err := os.WriteFile(path, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	reloadConfig()
+	if min, max := autoscaler.config().MinInstances, autoscaler.config().MaxInstances; min != 1 || max != 50 {
+		t.Errorf("bounds = %d-%d after removing them from the config file, want the environment's 1 and the default 50", min, max)
+	}
+}
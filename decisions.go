@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// decisionRecord describes one evaluation for the /decisions endpoint.
+type decisionRecord struct {
+	Time             time.Time `json:"time"`
+	CurrentInstances int       `json:"currentInstances"`
+	// ComputedInstances is the instance count the load called for, before
+	// any gate held it back. It is omitted for failsafe and override
+	// decisions.
+	ComputedInstances int     `json:"computedInstances,omitempty"`
+	DesiredInstances  int     `json:"desiredInstances"`
+	Backlog           int     `json:"backlog"`
+	AverageJobs       float64 `json:"averageJobs"`
+	Reason            string  `json:"reason"`
+	Action            string  `json:"action"`
+	Applied           bool    `json:"applied"`
+	// Gate names what held the instance count at its current value, such
+	// as scale-up-delay or quiet-hours.
+	Gate string `json:"gate,omitempty"`
+}
+
+// recordDecision appends an evaluation's outcome to the decision buffer,
+// dropping the oldest record once DecisionBufferSize is reached. Probes
+// aren't recorded. It must be called with the state mutex held.
+func recordDecision(d scaleDecision, applied bool) {
+	size := autoscaler.config().DecisionBufferSize
+	if size <= 0 || autoscaler.probing {
+		return
+	}
+	action := "hold"
+	if d.To > d.From {
+		action = "scale-up"
+	} else if d.To < d.From {
+		action = "scale-down"
+	}
+	record := decisionRecord{
+		Time:             autoscaler.clock.Now(),
+		CurrentInstances: d.From,
+		DesiredInstances: d.To,
+		Backlog:          d.Backlog,
+		Reason:           d.Reason,
+		Action:           action,
+		Applied:          applied,
+	}
+	if d.Reason == "load" {
+		record.ComputedInstances = autoscaler.lastComputed
+		record.AverageJobs = autoscaler.lastAverage
+	}
+	record.Gate = autoscaler.lastGate
+	autoscaler.decisions = append(autoscaler.decisions, record)
+	if len(autoscaler.decisions) > size {
+		autoscaler.decisions = autoscaler.decisions[len(autoscaler.decisions)-size:]
+	}
+}
+
+// handleDecisions returns the buffered decision records, oldest first.
+func handleDecisions(w http.ResponseWriter, r *http.Request) {
+	autoscaler.mu.Lock()
+	decisions := make([]decisionRecord, len(autoscaler.decisions))
+	copy(decisions, autoscaler.decisions)
+	autoscaler.mu.Unlock()
+	writeJSON(w, decisions)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/tidwall/gjson"
+)
+
+// getLatestDeployStatus returns the status of the worker service's most
+// recent deploy.
+func getLatestDeployStatus(ctx context.Context) (string, error) {
+	path := fmt.Sprintf("/services/%s/deploys?limit=1", autoscaler.config().WorkerServiceId)
+	status, resp, err := renderAPICall(ctx, workerServiceAPIKey(), "GET", path, "")
+	if err != nil {
+		return "", err
+	}
+	if status != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %d", status)
+	}
+	deploy := gjson.Get(resp, "0")
+	if inner := deploy.Get("deploy"); inner.Exists() {
+		deploy = inner
+	}
+	return deploy.Get("status").String(), nil
+}
+
+// deployInProgress reports whether the worker service is mid-deploy, checking the
+// Render API at most once per DeployCheckInterval. It must not be called with
+// the state mutex held.
+func deployInProgress(ctx context.Context) bool {
+	if !autoscaler.config().DeployFreeze {
+		return false
+	}
+	autoscaler.mu.Lock()
+	fresh := time.Since(autoscaler.deployCheckTime) < autoscaler.config().DeployCheckInterval
+	cached := autoscaler.deploying
+	autoscaler.mu.Unlock()
+	if fresh {
+		return cached
+	}
+
+	status, err := getLatestDeployStatus(ctx)
+	if err != nil {
+		recordError("render", "failed to retrieve deploy status from render: %v", err)
+		return cached
+	}
+	inProgress := false
+	switch status {
+	case "created", "build_in_progress", "update_in_progress", "pre_deploy_in_progress":
+		inProgress = true
+	}
+
+	autoscaler.mu.Lock()
+	defer autoscaler.mu.Unlock()
+	if inProgress && !autoscaler.deploying {
+		log.Infof("worker service deploy is %s, freezing scaling decisions", status)
+	} else if !inProgress && autoscaler.deploying {
+		log.Infof("worker service deploy is %s, resuming scaling decisions", status)
+	}
+	autoscaler.deploying = inProgress
+	autoscaler.deployCheckTime = time.Now()
+	return inProgress
+}
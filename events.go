@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// scaleEventsUnavailable is set once the events endpoint has returned 404, so
+// we stop trying.
+var scaleEventsUnavailable int32
+
+// reportScaleEvent posts a scale annotation to ScaleEventPath on the Render
+// API. It is best effort: failures are logged at debug level only.
+func reportScaleEvent(ctx context.Context, d scaleDecision) {
+	path := autoscaler.config().ScaleEventPath
+	if path == "" || atomic.LoadInt32(&scaleEventsUnavailable) == 1 {
+		return
+	}
+	path = strings.ReplaceAll(path, "{serviceId}", autoscaler.config().WorkerServiceId)
+	body, err := json.Marshal(map[string]interface{}{
+		"type":   "autoscale",
+		"from":   d.From,
+		"to":     d.To,
+		"reason": d.Reason,
+	})
+	if err != nil {
+		return
+	}
+	status, _, err := renderAPICall(ctx, workerServiceAPIKey(), "POST", path, string(body))
+	if err != nil {
+		log.Debugf("failed to report scale event: %v", err)
+		return
+	}
+	if status == http.StatusNotFound {
+		log.Debug("scale event endpoint not found, no longer reporting scale events")
+		atomic.StoreInt32(&scaleEventsUnavailable, 1)
+		return
+	}
+	if status >= http.StatusBadRequest {
+		log.Debugf("failed to report scale event: status %d", status)
+	}
+}
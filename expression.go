@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"math"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// expressionVariables are the names a DesiredExpression can refer to.
+var expressionVariables = map[string]bool{
+	"activeJobs":        true,
+	"pendingJobs":       true,
+	"currentInstances":  true,
+	"avgJobs":           true,
+	"minutesSinceScale": true,
+}
+
+// expressionFunctions are the functions a DesiredExpression can call, with
+// their number of arguments.
+var expressionFunctions = map[string]int{
+	"min":   2,
+	"max":   2,
+	"ceil":  1,
+	"floor": 1,
+}
+
+// parseExpression parses an arithmetic expression over numbers, the
+// expressionVariables and the expressionFunctions, with Go syntax for the
+// operators +, -, * and / and for parentheses. Nothing else is accepted, so
+// evaluating the result can't have side effects.
+func parseExpression(s string) (ast.Expr, error) {
+	expr, err := parser.ParseExpr(s)
+	if err != nil {
+		return nil, err
+	}
+	return expr, checkExpression(expr)
+}
+
+func checkExpression(expr ast.Expr) error {
+	switch e := expr.(type) {
+	case *ast.BasicLit:
+		if e.Kind != token.INT && e.Kind != token.FLOAT {
+			return fmt.Errorf("unsupported literal %s", e.Value)
+		}
+	case *ast.Ident:
+		if !expressionVariables[e.Name] {
+			return fmt.Errorf("unknown variable %s", e.Name)
+		}
+	case *ast.ParenExpr:
+		return checkExpression(e.X)
+	case *ast.UnaryExpr:
+		if e.Op != token.SUB && e.Op != token.ADD {
+			return fmt.Errorf("unsupported operator %s", e.Op)
+		}
+		return checkExpression(e.X)
+	case *ast.BinaryExpr:
+		switch e.Op {
+		case token.ADD, token.SUB, token.MUL, token.QUO:
+		default:
+			return fmt.Errorf("unsupported operator %s", e.Op)
+		}
+		if err := checkExpression(e.X); err != nil {
+			return err
+		}
+		return checkExpression(e.Y)
+	case *ast.CallExpr:
+		fn, ok := e.Fun.(*ast.Ident)
+		if !ok {
+			return fmt.Errorf("unsupported function call")
+		}
+		n, ok := expressionFunctions[fn.Name]
+		if !ok {
+			return fmt.Errorf("unknown function %s", fn.Name)
+		}
+		if len(e.Args) != n || e.Ellipsis.IsValid() {
+			return fmt.Errorf("%s takes %d arguments", fn.Name, n)
+		}
+		for _, arg := range e.Args {
+			if err := checkExpression(arg); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("unsupported expression")
+	}
+	return nil
+}
+
+// evaluateExpression evaluates an expression returned by parseExpression.
+func evaluateExpression(expr ast.Expr, vars map[string]float64) (float64, error) {
+	switch e := expr.(type) {
+	case *ast.BasicLit:
+		return strconv.ParseFloat(e.Value, 64)
+	case *ast.Ident:
+		return vars[e.Name], nil
+	case *ast.ParenExpr:
+		return evaluateExpression(e.X, vars)
+	case *ast.UnaryExpr:
+		x, err := evaluateExpression(e.X, vars)
+		if e.Op == token.SUB {
+			x = -x
+		}
+		return x, err
+	case *ast.BinaryExpr:
+		x, err := evaluateExpression(e.X, vars)
+		if err != nil {
+			return 0, err
+		}
+		y, err := evaluateExpression(e.Y, vars)
+		if err != nil {
+			return 0, err
+		}
+		switch e.Op {
+		case token.ADD:
+			return x + y, nil
+		case token.SUB:
+			return x - y, nil
+		case token.MUL:
+			return x * y, nil
+		}
+		if y == 0 {
+			return 0, fmt.Errorf("division by zero")
+		}
+		return x / y, nil
+	case *ast.CallExpr:
+		args := make([]float64, len(e.Args))
+		for i, arg := range e.Args {
+			x, err := evaluateExpression(arg, vars)
+			if err != nil {
+				return 0, err
+			}
+			args[i] = x
+		}
+		switch e.Fun.(*ast.Ident).Name {
+		case "min":
+			return math.Min(args[0], args[1]), nil
+		case "max":
+			return math.Max(args[0], args[1]), nil
+		case "ceil":
+			return math.Ceil(args[0]), nil
+		case "floor":
+			return math.Floor(args[0]), nil
+		}
+	}
+	return 0, fmt.Errorf("unsupported expression")
+}
+
+// expressionInstances evaluates DesiredExpression for the aggregated load and
+// the measured active and pending job counts, rounding the result up to whole
+// instances. If the expression can't be evaluated, the computed count is
+// kept. It must be called with the state mutex held.
+func expressionInstances(avgJobs float64, now time.Time, computed int) int {
+	active := autoscaler.activeJobs
+	if active < 0 {
+		active = 0
+	}
+	pending := autoscaler.pendingJobs
+	if pending < 0 {
+		pending = 0
+	}
+	result, err := evaluateExpression(autoscaler.desiredExpression, map[string]float64{
+		"activeJobs":        float64(active),
+		"pendingJobs":       float64(pending),
+		"currentInstances":  float64(autoscaler.instances),
+		"avgJobs":           avgJobs,
+		"minutesSinceScale": now.Sub(autoscaler.lastScaleTime).Minutes(),
+	})
+	if err != nil || math.IsNaN(result) {
+		log.Warnf("failed to evaluate DESIRED_EXPRESSION, using the computed %d instances: %v", computed, err)
+		return computed
+	}
+	if result < 0 {
+		return 0
+	}
+	return int(math.Ceil(math.Min(result, math.MaxInt32)))
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestExpressionUsesMeasuredPendingJobs(t *testing.T) {
+	e := setupTest(t, 1, map[string]string{
+		"MIN_INSTANCES":      "1",
+		"SCALE_UP_DELAY":     "0s",
+		"SYNTHETIC_LOAD":     "sequence:1h:100",
+		"DESIRED_EXPRESSION": "pendingJobs",
+	})
+	// the load is synthetic, so it says nothing about the jobs enqueued
+	e.setQueues(t, map[string]int{"default": 3})
+	e.setWorkers(t, 2, 1)
+
+	e.clock.Advance(time.Minute)
+	if d, _ := evaluate(context.Background(), true); d.To != 3 {
+		t.Errorf("desired %d instances for an expression of pendingJobs, want the 3 enqueued", d.To)
+	}
+}
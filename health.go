@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+// instancesPageSize is the number of instances requested per page.
+const instancesPageSize = 100
+
+// getInstanceStatuses returns the status of each instance of the worker
+// service, following the cursor through every page. List items may be bare
+// instances or wrapped with a cursor.
+func getInstanceStatuses(ctx context.Context) ([]string, error) {
+	var statuses []string
+	cursor := ""
+	for {
+		path := fmt.Sprintf("/services/%s/instances?limit=%d", autoscaler.config().WorkerServiceId, instancesPageSize)
+		if cursor != "" {
+			path += "&cursor=" + url.QueryEscape(cursor)
+		}
+		status, resp, err := renderAPICall(ctx, workerServiceAPIKey(), "GET", path, "")
+		if err != nil {
+			return nil, err
+		}
+		if status != http.StatusOK {
+			return nil, fmt.Errorf("unexpected status %d", status)
+		}
+		items := 0
+		cursor = ""
+		gjson.Parse(resp).ForEach(func(_, item gjson.Result) bool {
+			items++
+			cursor = item.Get("cursor").String()
+			if instance := item.Get("instance"); instance.Exists() {
+				item = instance
+			}
+			statuses = append(statuses, item.Get("status").String())
+			return true
+		})
+		if items < instancesPageSize || cursor == "" {
+			return statuses, nil
+		}
+	}
+}
+
+// refreshHealth updates the cached healthy instance ratio and count if they
+// are older than HealthCheckInterval. It must not be called with the state
+// mutex held.
+func refreshHealth(ctx context.Context) {
+	if autoscaler.config().MinHealthyRatio <= 0 && !autoscaler.config().CapacityAware {
+		return
+	}
+	autoscaler.mu.Lock()
+	fresh := time.Since(autoscaler.healthCheckTime) < autoscaler.config().HealthCheckInterval
+	autoscaler.mu.Unlock()
+	if fresh {
+		return
+	}
+
+	statuses, err := getInstanceStatuses(ctx)
+	if err != nil {
+		recordError("render", "failed to retrieve instance statuses from render: %v", err)
+		return
+	}
+	healthy := 0
+	for _, status := range statuses {
+		for _, s := range autoscaler.config().HealthyStatuses {
+			if status == s {
+				healthy++
+				break
+			}
+		}
+	}
+	ratio := 1.0
+	if len(statuses) > 0 {
+		ratio = float64(healthy) / float64(len(statuses))
+	}
+
+	autoscaler.mu.Lock()
+	autoscaler.healthyRatio = ratio
+	autoscaler.healthyInstances = healthy
+	autoscaler.healthCheckTime = time.Now()
+	autoscaler.mu.Unlock()
+}
+
+// effectiveInstances returns the number of instances actually processing
+// jobs: with CapacityAware set, the healthy instances last reported by
+// Render, and otherwise the tracked instance count. It must be called with
+// the state mutex held.
+func effectiveInstances() int {
+	if !autoscaler.config().CapacityAware || autoscaler.healthyInstances < 0 {
+		return autoscaler.instances
+	}
+	return autoscaler.healthyInstances
+}
+
+// unavailableInstances returns how many of the instances a desired count
+// would keep are not processing jobs, so that they can be added on top of
+// it. It is zero unless CapacityAware is set, and for the cpu strategy, which
+// already measures against effectiveInstances. It must be called with the
+// state mutex held.
+func unavailableInstances(desired int) int {
+	if !autoscaler.config().CapacityAware || autoscaler.healthyInstances < 0 ||
+		autoscaler.config().ScalingStrategy == "cpu" {
+		return 0
+	}
+	kept := desired
+	if autoscaler.instances < kept {
+		kept = autoscaler.instances
+	}
+	if unavailable := kept - autoscaler.healthyInstances; unavailable > 0 {
+		return unavailable
+	}
+	return 0
+}
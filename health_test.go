@@ -0,0 +1,28 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCapacityAwareDesiredInstances(t *testing.T) {
+	e := setupTest(t, 4, map[string]string{
+		"MIN_INSTANCES":  "1",
+		"CAPACITY_AWARE": "true",
+		"SCALE_UP_DELAY": "0s",
+	})
+	autoscaler.mu.Lock()
+	autoscaler.healthyInstances = 2
+	autoscaler.mu.Unlock()
+	// 6 jobs need 6 running instances, and 2 of the 4 kept aren't running
+	if got := e.desiredAt(time.Second, 6); got != 8 {
+		t.Errorf("desired %d instances with 2 of 4 instances running, want 8", got)
+	}
+
+	autoscaler.mu.Lock()
+	autoscaler.healthyInstances = 8
+	autoscaler.mu.Unlock()
+	if got := e.desiredAt(time.Second, 9); got != 9 {
+		t.Errorf("desired %d instances for 9 jobs with every instance running, want 9", got)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// refreshHistory records a job count in the HistoryKey sorted set at most
+// once per HistoryRecordInterval, trims observations older than
+// HistoryWindow, and derives the minimum instance floor from the
+// HistoryPercentile of what remains. Observations are keyed by time so that
+// the history survives restarts and is shared between replicas. It must not
+// be called with the state mutex held.
+func refreshHistory(ctx context.Context, jobs int) {
+	key := autoscaler.config().HistoryKey
+	if key == "" {
+		return
+	}
+	autoscaler.mu.Lock()
+	fresh := time.Since(autoscaler.historyRecordTime) < autoscaler.config().HistoryRecordInterval
+	autoscaler.mu.Unlock()
+	if fresh {
+		return
+	}
+
+	now := time.Now()
+	window := autoscaler.config().HistoryWindow
+	cutoff := strconv.FormatInt(now.Add(-window).UnixNano(), 10)
+	pipe := autoscaler.redis.TxPipeline()
+	pipe.ZAdd(ctx, key, &redis.Z{
+		Score:  float64(now.UnixNano()),
+		Member: fmt.Sprintf("%d:%d", now.UnixNano(), jobs),
+	})
+	pipe.ZRemRangeByScore(ctx, key, "-inf", "("+cutoff)
+	pipe.Expire(ctx, key, window)
+	members := pipe.ZRange(ctx, key, 0, -1)
+	if _, err := pipe.Exec(ctx); err != nil {
+		recordError("redis", "failed to record load history in redis: %v", err)
+		return
+	}
+
+	var counts []int
+	for _, member := range members.Val() {
+		n, err := strconv.Atoi(member[strings.LastIndex(member, ":")+1:])
+		if err == nil {
+			counts = append(counts, n)
+		}
+	}
+	floor := 0
+	if len(counts) > 0 {
+		sort.Ints(counts)
+		p := nearestRank(counts, autoscaler.config().HistoryPercentile)
+		floor = int(math.Ceil(float64(p) / workersPerInstance()))
+	}
+	historyFloorInstances.Set(float64(floor))
+
+	autoscaler.mu.Lock()
+	defer autoscaler.mu.Unlock()
+	autoscaler.historyFloor = floor
+	autoscaler.historyRecordTime = now
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// iterate runs one iteration of the evaluation loop against the scale loop
+// and returns the decision along with whether it was applied.
+func (e *testEnv) iterate(t *testing.T) (scaleDecision, bool) {
+	t.Helper()
+	scales := len(e.render.Scales())
+	d, applied, ok := evaluateWithTimeout(autoscaler.scaleChan, true)
+	if !ok {
+		t.Fatal("evaluation timed out")
+	}
+	if !applied {
+		return d, false
+	}
+	if !sendDecision(autoscaler.scaleChan, d) {
+		t.Fatal("scale loop exited")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(e.render.Scales()) == scales {
+		if time.Now().After(deadline) {
+			t.Fatalf("render was never asked to scale to %d instances", d.To)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return d, true
+}
+
+func TestScalingLoop(t *testing.T) {
+	e := setupTest(t, 1, map[string]string{
+		"MIN_INSTANCES":        "1",
+		"MAX_INSTANCES":        "10",
+		"WORKERS_PER_INSTANCE": "5",
+		"SCALE_UP_DELAY":       "1m",
+		"SCALE_DOWN_DELAY":     "5m",
+	})
+	if autoscaler.instances != 1 {
+		t.Fatalf("instances = %d at startup, want the 1 render reports", autoscaler.instances)
+	}
+	e.startScaleLoop(t)
+
+	steps := []struct {
+		name    string
+		advance time.Duration
+		pending int
+		busy    int
+		want    int
+		applied bool
+	}{
+		{"backlog within scale-up delay", 30 * time.Second, 20, 0, 1, false},
+		{"backlog after scale-up delay", 31 * time.Second, 20, 0, 4, true},
+		{"active jobs count towards load", 30 * time.Second, 20, 5, 4, false},
+		{"active jobs after scale-up delay", 31 * time.Second, 20, 5, 5, true},
+		{"drained within scale-down delay", 2 * time.Minute, 0, 0, 5, false},
+		{"drained after scale-down delay", 3*time.Minute + time.Second, 0, 0, 1, true},
+		{"idle", time.Minute, 0, 0, 1, false},
+		{"backlog above maximum", 2 * time.Minute, 100, 0, 10, true},
+	}
+	for _, step := range steps {
+		e.clock.Advance(step.advance)
+		e.setQueues(t, map[string]int{"default": step.pending})
+		e.setWorkers(t, step.busy, step.busy)
+		d, applied := e.iterate(t)
+		if d.To != step.want || applied != step.applied {
+			t.Errorf("%s: desired %d instances (applied %v), want %d (applied %v)",
+				step.name, d.To, applied, step.want, step.applied)
+		}
+	}
+	if got, want := e.render.Scales(), []int{4, 5, 1, 10}; !reflect.DeepEqual(got, want) {
+		t.Errorf("render scaled to %v, want %v", got, want)
+	}
+}
+
+func TestScalingLoopFailsafe(t *testing.T) {
+	e := setupTest(t, 4, map[string]string{
+		"MIN_INSTANCES":            "1",
+		"MAX_CONSECUTIVE_FAILURES": "2",
+		"FAILSAFE_INSTANCES":       "6",
+	})
+	e.startScaleLoop(t)
+	e.redis.Close()
+
+	if d, applied := e.iterate(t); d.To != 4 || applied {
+		t.Errorf("after one failure: desired %d instances (applied %v), want 4 held", d.To, applied)
+	}
+	if d, applied := e.iterate(t); d.To != 6 || !applied {
+		t.Errorf("after two failures: desired %d instances (applied %v), want 6 applied", d.To, applied)
+	}
+	if got, want := e.render.Scales(), []int{6}; !reflect.DeepEqual(got, want) {
+		t.Errorf("render scaled to %v, want %v", got, want)
+	}
+}
+
+func TestScalingLoopOverride(t *testing.T) {
+	e := setupTest(t, 2, map[string]string{"MIN_INSTANCES": "1"})
+	e.startScaleLoop(t)
+	e.setQueues(t, map[string]int{"default": 50})
+	if err := setOverride(3, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	if d, applied := e.iterate(t); d.To != 3 || !applied || d.Reason != "override" {
+		t.Errorf("desired %d instances (applied %v, %s), want 3 applied by override", d.To, applied, d.Reason)
+	}
+	if got, want := e.render.Scales(), []int{3}; !reflect.DeepEqual(got, want) {
+		t.Errorf("render scaled to %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+// headLatency returns how long the job at the head of a list queue has been
+// waiting, based on the enqueue timestamp at EnqueuedAtPath in its payload.
+// ok is false if the queue is empty or the payload carries no timestamp.
+func headLatency(ctx context.Context, queueKey string) (latency time.Duration, ok bool) {
+	payload, err := autoscaler.redis.LIndex(ctx, queueKey, 0).Result()
+	if err != nil {
+		return 0, false
+	}
+	enqueuedAt, ok := parseTimestamp(gjson.Get(payload, autoscaler.config().EnqueuedAtPath))
+	if !ok {
+		return 0, false
+	}
+	latency = time.Since(enqueuedAt)
+	if latency < 0 {
+		latency = 0
+	}
+	return latency, true
+}
+
+// parseTimestamp accepts unix timestamps in seconds or milliseconds and
+// RFC 3339 strings.
+func parseTimestamp(value gjson.Result) (time.Time, bool) {
+	switch value.Type {
+	case gjson.Number:
+		if value.Num > 1e12 {
+			return time.UnixMilli(value.Int()), true
+		}
+		return time.Unix(0, int64(value.Num*float64(time.Second))), true
+	case gjson.String:
+		if t, err := time.Parse(time.RFC3339, value.Str); err == nil {
+			return t, true
+		}
+		if n := gjson.Parse(value.Str); n.Type == gjson.Number {
+			return parseTimestamp(n)
+		}
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	log "github.com/sirupsen/logrus"
+)
+
+// renewScript extends the leader lock only if this replica still holds it.
+var renewScript = redis.NewScript(`
+if redis.call("get", KEYS[1]) == ARGV[1] then
+	return redis.call("pexpire", KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// leaderID identifies this replica as the holder of the leader lock.
+var leaderID = func() string {
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s:%d:%d", host, os.Getpid(), time.Now().UnixNano())
+}()
+
+// isLeader reports whether this replica may scale. Without leader election
+// every replica is the leader.
+func isLeader() bool {
+	return autoscaler.config().LeaderLockKey == "" || atomic.LoadInt32(&autoscaler.leader) == 1
+}
+
+// campaign acquires or renews the leader lock and records the outcome. A
+// leader that cannot confirm it still holds the lock steps down, since
+// another replica may take over once the lock expires.
+func campaign() {
+	key, ttl := autoscaler.config().LeaderLockKey, autoscaler.config().LeaderLockTTL
+	var held bool
+	var err error
+	action := "acquire"
+	if atomic.LoadInt32(&autoscaler.leader) == 1 {
+		action = "renew"
+		var n int
+		n, err = renewScript.Run(autoscaler.ctx, autoscaler.redis, []string{key}, leaderID, ttl.Milliseconds()).Int()
+		held = n == 1
+	} else {
+		held, err = autoscaler.redis.SetNX(autoscaler.ctx, key, leaderID, ttl).Result()
+	}
+	if err != nil {
+		recordError("redis", "failed to %s leader lock: %v", action, err)
+		held = false
+	}
+
+	if !held {
+		if atomic.SwapInt32(&autoscaler.leader, 0) == 1 {
+			log.Warn("lost leadership, standing by")
+		}
+		leaderGauge.Set(0)
+		return
+	}
+	if atomic.SwapInt32(&autoscaler.leader, 1) == 0 {
+		log.Info("acquired leadership")
+		// the previous leader may have scaled since the count was read
+		count := getInstanceCount(autoscaler.ctx)
+		autoscaler.mu.Lock()
+		autoscaler.instances = count
+		autoscaler.mu.Unlock()
+	}
+	leaderGauge.Set(1)
+}
+
+// leaderLoop campaigns for leadership every third of the lock's TTL, so a
+// leader renews the lock well before it expires.
+func leaderLoop() {
+	for {
+		time.Sleep(autoscaler.config().LeaderLockTTL / 3)
+		campaign()
+	}
+}
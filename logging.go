@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	log "github.com/sirupsen/logrus"
+)
+
+// fieldsHook adds a fixed set of fields to every log entry that doesn't
+// already set them.
+type fieldsHook struct {
+	fields log.Fields
+}
+
+func (h fieldsHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+func (h fieldsHook) Fire(entry *log.Entry) error {
+	for k, v := range h.fields {
+		if _, ok := entry.Data[k]; !ok {
+			entry.Data[k] = v
+		}
+	}
+	return nil
+}
+
+// logConfig logs every resolved config value, including defaults, on one
+// line. Secrets are masked if set.
+func logConfig(config AutoscalerConfig) {
+	log.WithFields(configFields(config)).Info("resolved config")
+}
+
+// metricsLogLoop periodically logs the autoscaler's own metrics on one line,
+// for deployments that don't scrape /metrics. Values come from the same
+// registry /metrics serves. Histograms and summaries are logged as their
+// count and sum.
+func metricsLogLoop(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		families, err := prometheus.DefaultGatherer.Gather()
+		if err != nil {
+			log.Errorf("failed to gather metrics: %v", err)
+			continue
+		}
+		fields := log.Fields{}
+		for _, family := range families {
+			name := family.GetName()
+			if !strings.HasPrefix(name, metricsNamespace+"_") {
+				continue
+			}
+			for _, m := range family.GetMetric() {
+				key := name + metricLabels(m)
+				switch {
+				case m.Gauge != nil:
+					fields[key] = m.Gauge.GetValue()
+				case m.Counter != nil:
+					fields[key] = m.Counter.GetValue()
+				case m.Histogram != nil:
+					fields[key+"_count"] = m.Histogram.GetSampleCount()
+					fields[key+"_sum"] = m.Histogram.GetSampleSum()
+				case m.Summary != nil:
+					fields[key+"_count"] = m.Summary.GetSampleCount()
+					fields[key+"_sum"] = m.Summary.GetSampleSum()
+				}
+			}
+		}
+		log.WithFields(fields).Info("metrics")
+	}
+}
+
+// metricLabels formats m's labels the way the exposition format does, e.g.
+// {kind="redis"}, or returns "" if m has none.
+func metricLabels(m *dto.Metric) string {
+	if len(m.GetLabel()) == 0 {
+		return ""
+	}
+	pairs := make([]string, 0, len(m.GetLabel()))
+	for _, l := range m.GetLabel() {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", l.GetName(), l.GetValue()))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
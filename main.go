@@ -3,11 +3,18 @@ package main
 import (
 	"context"
 	"fmt"
+	"go/ast"
 	"io"
 	"io/ioutil"
 	"math"
+	"net"
 	"net/http"
+	"net/textproto"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-redis/redis/v8"
@@ -17,197 +24,2115 @@ import (
 )
 
 type AutoscalerConfig struct {
-	WorkerServiceId    string        `required:"true" split_words:"true"`
-	RenderAPIKey       string        `required:"true" split_words:"true"`
-	RedisAddress       string        `required:"true" split_words:"true"`
-	MinInstances       int           `default:"2" split_words:"true"`
-	MaxInstances       int           `default:"50" split_words:"true"`
-	WorkersPerInstance int           `default:"1" split_words:"true"`
-	Interval           time.Duration `default:"1s"`
-	NumSamples         int           `default:"1" split_words:"true"`
-	ScaleUpDelay       time.Duration `default:"1m" split_words:"true"`
-	ScaleDownDelay     time.Duration `default:"10m" split_words:"true"`
+	WorkerServiceId        string             `required:"true" split_words:"true"`
+	LogLevel               log.Level          `default:"info" split_words:"true"`
+	RenderAPIKey           string             `split_words:"true"`
+	RenderAPIKeyFile       string             `split_words:"true"`
+	WorkerServiceAPIKey    string             `split_words:"true"`
+	MaxResponseBytes       int64              `default:"10485760" split_words:"true"`
+	AuditStream            string             `split_words:"true"`
+	OutputSmoothingSamples int                `split_words:"true"`
+	ScaleEventPath         string             `split_words:"true"`
+	InstanceHourlyCost     float64            `split_words:"true"`
+	ReconcileDrift         bool               `split_words:"true"`
+	ReconcileInterval      time.Duration      `default:"5m" split_words:"true"`
+	EnqueuedAtPath         string             `split_words:"true"`
+	QueueLatencySLO        time.Duration      `split_words:"true"`
+	RedisAddress           string             `split_words:"true"`
+	StatsSource            string             `default:"redis" split_words:"true"`
+	StatsURL               string             `split_words:"true"`
+	StatsPendingPath       string             `default:"pending" split_words:"true"`
+	StatsWorkingPath       string             `default:"working" split_words:"true"`
+	StatsProcessedPath     string             `default:"processed" split_words:"true"`
+	StatsFailedPath        string             `default:"failed" split_words:"true"`
+	RenderAPIBaseURL       string             `default:"https://api.render.com" split_words:"true"`
+	RenderAPIVersion       string             `default:"v1" split_words:"true"`
+	MinInstances           int                `default:"2" split_words:"true"`
+	MaxInstances           int                `default:"50" split_words:"true"`
+	WorkersPerInstance     int                `default:"1" split_words:"true"`
+	Interval               time.Duration      `default:"1s"`
+	NumSamples             int                `default:"1" split_words:"true"`
+	Aggregation            string             `default:"mean"`
+	ScaleUpDelay           time.Duration      `default:"1m" split_words:"true"`
+	ScaleDownDelay         time.Duration      `default:"10m" split_words:"true"`
+	ScalingProfile         string             `split_words:"true"`
+	ListenAddress          string             `split_words:"true"`
+	BreakerThreshold       int                `default:"5" split_words:"true"`
+	BreakerCooldown        time.Duration      `default:"1m" split_words:"true"`
+	IdleJobThreshold       int                `split_words:"true"`
+	AdminSecret            string             `split_words:"true"`
+	OverrideKey            string             `default:"resque-autoscaler:override" split_words:"true"`
+	GrowthBoostFactor      float64            `default:"1" split_words:"true"`
+	GrowthBoostSamples     int                `default:"3" split_words:"true"`
+	GrowthThreshold        float64            `split_words:"true"`
+	PredictionHorizon      time.Duration      `split_words:"true"`
+	QueueRatios            map[string]float64 `split_words:"true"`
+	WarmupPeriod           time.Duration      `split_words:"true"`
+	QueueScanCount         int64              `split_words:"true"`
+	MaxConsecutiveFailures int                `split_words:"true"`
+	FailsafeInstances      *int               `split_words:"true"`
+	AlertWebhookURL        string             `split_words:"true"`
+	BoundsKey              string             `default:"resque-autoscaler:bounds" split_words:"true"`
+	PayloadWeightPaths     map[string]string  `split_words:"true"`
+	PayloadSampleSize      int64              `default:"100" split_words:"true"`
+	ScalingStrategy        string             `default:"queue-depth" split_words:"true"`
+	CPUTarget              float64            `default:"0.7" split_words:"true"`
+	CPUWindow              time.Duration      `default:"5m" split_words:"true"`
+	AvgJobDuration         time.Duration      `split_words:"true"`
+	MaxConsecutiveScaleUps int                `split_words:"true"`
+	ShadowEvaluations      []string           `split_words:"true"`
+	RedisPollInterval      time.Duration      `split_words:"true"`
+	MaxBacklog             int64              `default:"1000000000" split_words:"true"`
+	DryRun                 bool               `split_words:"true"`
+	QueueGroups            map[string]string  `split_words:"true"`
+	QueueGroupRatios       map[string]float64 `split_words:"true"`
+	QueueGroupMode         string             `default:"sum" split_words:"true"`
+	MinHealthyRatio        float64            `split_words:"true"`
+	HealthyStatuses        []string           `default:"available" split_words:"true"`
+	HealthCheckInterval    time.Duration      `default:"1m" split_words:"true"`
+	StartupScaleToMin      bool               `split_words:"true"`
+	QuotaKey               string             `split_words:"true"`
+	QuotaRefreshInterval   time.Duration      `default:"1m" split_words:"true"`
+	ScaleDownMode          string             `default:"default" split_words:"true"`
+	StartupGracePeriod     time.Duration      `split_words:"true"`
+	WorkerChurnThreshold   float64            `split_words:"true"`
+	WorkerChurnWindow      time.Duration      `default:"10m" split_words:"true"`
+	MinInterval            time.Duration      `split_words:"true"`
+	MaxInterval            time.Duration      `split_words:"true"`
+	FirstScaleUpDelay      *time.Duration     `split_words:"true"`
+	LeaderLockKey          string             `split_words:"true"`
+	LeaderLockTTL          time.Duration      `default:"15s" split_words:"true"`
+	DrainTimeTarget        time.Duration      `split_words:"true"`
+	DrainTimeCombine       string             `default:"max" split_words:"true"`
+	BacklogBaseline        int                `split_words:"true"`
+	HistoryKey             string             `split_words:"true"`
+	HistoryWindow          time.Duration      `default:"6h" split_words:"true"`
+	HistoryPercentile      float64            `default:"95" split_words:"true"`
+	HistoryRecordInterval  time.Duration      `default:"1m" split_words:"true"`
+	RenderMaxIdleConns     int                `default:"10" split_words:"true"`
+	RenderIdleConnTimeout  time.Duration      `default:"90s" split_words:"true"`
+	BaseInstances          int                `split_words:"true"`
+	DecisionBufferSize     int                `default:"100" split_words:"true"`
+	ScheduledLookahead     time.Duration      `split_words:"true"`
+	ScheduledThreshold     int64              `split_words:"true"`
+	MinNonEmptySamples     int                `split_words:"true"`
+	RenderHeaders          map[string]string  `split_words:"true"`
+	SafeScaleDown          bool               `split_words:"true"`
+	SafeScaleDownWindow    time.Duration      `default:"10m" split_words:"true"`
+	CapacityAware          bool               `split_words:"true"`
+	DynamicDelay           bool               `split_words:"true"`
+	DynamicDelayMinBacklog int                `default:"10" split_words:"true"`
+	DynamicDelayMaxBacklog int                `default:"1000" split_words:"true"`
+	WorkersEnvVar          string             `split_words:"true"`
+	WorkersRefreshInterval time.Duration      `default:"5m" split_words:"true"`
+	ConfigFile             string             `split_words:"true"`
+	TriggerChannel         string             `split_words:"true"`
+	LogEveryNIterations    int                `split_words:"true"`
+	ScaleToOnShutdown      *int               `split_words:"true"`
+	MinInstanceDelta       int                `split_words:"true"`
+	QueuePriorities        []string           `split_words:"true"`
+	PriorityDecay          float64            `default:"1" split_words:"true"`
+	DeployFreeze           bool               `split_words:"true"`
+	DeployCheckInterval    time.Duration      `default:"30s" split_words:"true"`
+	CombineAggregations    []string           `split_words:"true"`
+	EvaluationTimeout      time.Duration      `split_words:"true"`
+	MetricsLogInterval     time.Duration      `split_words:"true"`
+	ResqueNamespace        string             `default:"resque" split_words:"true"`
+	ResqueNamespaces       []string           `split_words:"true"`
+	LiveWorkerCapacity     bool               `split_words:"true"`
+	PausedQueueKey         string             `split_words:"true"`
+	StuckJobThreshold      time.Duration      `split_words:"true"`
+	DesiredExpression      string             `split_words:"true"`
+	DriftCheckInterval     time.Duration      `split_words:"true"`
+	TargetUtilization      float64            `default:"1" split_words:"true"`
+	MaxInstanceCountAge    time.Duration      `split_words:"true"`
+	AllowedInstanceCounts  []int              `split_words:"true"`
+	SyntheticLoad          string             `split_words:"true"`
+	ObserveOnlyDuration    time.Duration      `split_words:"true"`
+	ScaleDownMaxPending    *int               `split_words:"true"`
+	ScaleDownMaxActive     *int               `split_words:"true"`
+	QueueNonEmptySamples   map[string]int     `split_words:"true"`
+	Environment            string
+
+	BusinessHoursStart    string   `split_words:"true"`
+	BusinessHoursEnd      string   `split_words:"true"`
+	BusinessHoursTimezone string   `default:"UTC" split_words:"true"`
+	BusinessHoursMin      *int     `split_words:"true"`
+	OffHoursMin           int      `split_words:"true"`
+	BusinessDays          []string `split_words:"true"`
+
+	QuietHoursStart    string `split_words:"true"`
+	QuietHoursEnd      string `split_words:"true"`
+	QuietHoursTimezone string `default:"UTC" split_words:"true"`
+
+	QueueTargetWaits  map[string]time.Duration `split_words:"true"`
+	QueueArrivalRates map[string]float64       `split_words:"true"`
+	QueueJobDurations map[string]time.Duration `split_words:"true"`
 }
 
 type Autoscaler struct {
-	config        AutoscalerConfig
+	mu            sync.Mutex
+	currentConfig atomic.Value
 	instances     int
+	startTime     time.Time
 	lastScaleTime time.Time
-	samples       []int
+	lastScaleUp   time.Time
+	lastDesired   int
+	samples       *ringBuffer
+	outputs       *ringBuffer
 	redis         *redis.Client
 	ctx           context.Context
+	breaker       *circuitBreaker
+	clock         clock
+	idle          bool
+	scaleChan     chan scaleDecision
+	cancel        context.CancelFunc
+	override      bool
+	businessHours *timeWindow
+	quietHours    *timeWindow
+	quietLogged   bool
+	loadFailures  int
+	lastArrivals  *arrivalObservation
+
+	consecutiveScaleUps int
+	scaleUpFrozen       bool
+
+	shadows     []*shadowEvaluation
+	queueGroups []queueGroup
+
+	healthyRatio     float64
+	healthyInstances int
+	healthCheckTime  time.Time
+	unhealthy        bool
+
+	cachedLoad     int
+	cachedLoadTime time.Time
+	// the active and pending job counts measured along with the cached
+	// load, -1 when they weren't
+	cachedActive  int
+	cachedPending int64
+	// the stats document shared by the counters of the measurement in
+	// progress
+	statsSnapshotting bool
+	statsSnapshot     []byte
+	backlogClamped    int32
+	queueTypes        sync.Map
+
+	maxQueueLatency time.Duration
+
+	quota          int
+	quotaCheckTime time.Time
+	quotaBinding   bool
+
+	activeJobs    int
+	pendingJobs   int64
+	activeHistory []activeObservation
+
+	leader int32
+
+	nonEmptySamples map[string]int
+	transientJobs   int64
+
+	shuttingDown  bool
+	scaleLoopDone chan struct{}
+	evaluating    int32
+	evaluation    sync.Mutex
+	probing       bool
+
+	deploying       bool
+	deployCheckTime time.Time
+
+	lastAverage  float64
+	lastComputed int
+	lastGate     string
+	decisions    []decisionRecord
+
+	historyRecordTime time.Time
+	historyFloor      int
+
+	workersSeen map[string]time.Time
+	departures  []time.Time
+	churnRate   float64
+	churning    bool
+
+	liveWorkersPerInstance uint64
+	syncedWorkers          int64
+	pausedQueues           string
+	stuckWorkers           int
+	desiredExpression      ast.Expr
+	instanceCountTime      time.Time
+	countStale             bool
+	synthetic              *syntheticLoad
+	observing              bool
+
+	lastError               string
+	lastErrorTime           time.Time
+	errorCounts             map[string]int
+	lastSuccessfulScaleTime time.Time
+}
+
+// config returns the config in effect. It is safe to call with or without
+// the state mutex held, and the config it returns must not be modified.
+func (a *Autoscaler) config() *AutoscalerConfig {
+	return a.currentConfig.Load().(*AutoscalerConfig)
+}
+
+// setConfig replaces the config in effect. Changes derived from the current
+// config must be made with the state mutex held, so that concurrent changes
+// aren't lost.
+func (a *Autoscaler) setConfig(config AutoscalerConfig) {
+	a.currentConfig.Store(&config)
 }
 
 var autoscaler *Autoscaler
 
-func init() {
+// setup builds the global autoscaler from config, fetching the current
+// instance count and connecting to Redis. It exits on invalid settings that
+// loadConfig doesn't catch.
+func setup(config AutoscalerConfig) {
+	log.SetLevel(config.LogLevel)
+	fields := log.Fields{"service_id": config.WorkerServiceId}
+	if config.Environment != "" {
+		fields["environment"] = config.Environment
+	}
+	log.AddHook(fieldsHook{fields: fields})
+	autoscaler = &Autoscaler{clock: realClock{}, healthyRatio: 1, healthyInstances: -1, quota: -1}
+	autoscaler.setConfig(config)
+	// measure the scale delays from startup rather than letting the first
+	// reading scale immediately
+	autoscaler.startTime = autoscaler.clock.Now()
+	autoscaler.lastScaleTime = autoscaler.startTime
+	if config.ObserveOnlyDuration > 0 {
+		autoscaler.observing = true
+		log.Infof("observing only for %s after startup, not acting on scaling decisions", config.ObserveOnlyDuration)
+	}
+	if config.BusinessHoursStart != "" || config.BusinessHoursEnd != "" {
+		hours, err := parseTimeWindow(config.BusinessHoursStart, config.BusinessHoursEnd,
+			config.BusinessHoursTimezone, config.BusinessDays)
+		if err != nil {
+			log.Fatalf("invalid business hours: %v", err)
+		}
+		autoscaler.businessHours = hours
+	}
+	if config.QuietHoursStart != "" || config.QuietHoursEnd != "" {
+		hours, err := parseTimeWindow(config.QuietHoursStart, config.QuietHoursEnd,
+			config.QuietHoursTimezone, nil)
+		if err != nil {
+			log.Fatalf("invalid quiet hours: %v", err)
+		}
+		autoscaler.quietHours = hours
+	}
+	autoscaler.breaker = newCircuitBreaker(config.BreakerThreshold, config.BreakerCooldown)
+	autoscaler.ctx, autoscaler.cancel = context.WithCancel(context.Background())
+	renderClient = newRenderClient(config)
+	autoscaler.instances = getInstanceCount(autoscaler.ctx)
+	autoscaler.samples = newRingBuffer(config.NumSamples)
+	autoscaler.outputs = newRingBuffer(config.OutputSmoothingSamples)
+	shadows, err := parseShadowEvaluations(config.ShadowEvaluations)
+	if err != nil {
+		log.Fatal(err)
+	}
+	autoscaler.shadows = shadows
+	groups, err := parseQueueGroups(config.QueueGroups, config.QueueGroupRatios, float64(config.WorkersPerInstance))
+	if err != nil {
+		log.Fatal(err)
+	}
+	autoscaler.queueGroups = groups
+	if config.DesiredExpression != "" {
+		// already validated by loadConfig
+		autoscaler.desiredExpression, _ = parseExpression(config.DesiredExpression)
+	}
+	if config.SyntheticLoad != "" {
+		autoscaler.synthetic, _ = parseSyntheticLoad(config.SyntheticLoad)
+		log.Warnf("using synthetic load %q in place of the measured load", config.SyntheticLoad)
+	}
+	if config.RedisAddress != "" {
+		autoscaler.redis = redis.NewClient(&redis.Options{
+			Addr: config.RedisAddress,
+		})
+	}
+	autoscaler.scaleChan = make(chan scaleDecision)
+	autoscaler.scaleLoopDone = make(chan struct{})
+	loadBounds()
+	logConfig(config)
+}
+
+// loadConfig reads the config from the environment, and from ConfigFile if
+// one is set, applies defaults and validates it.
+func loadConfig() (AutoscalerConfig, error) {
 	var config AutoscalerConfig
+	restore, err := overlayConfigFile()
+	if err != nil {
+		return config, err
+	}
+	defer restore()
 	if err := envconfig.Process("", &config); err != nil {
-		log.Fatal(err)
+		return config, err
 	}
-	autoscaler = &Autoscaler{config: config}
-	autoscaler.instances = getInstanceCount()
-	autoscaler.redis = redis.NewClient(&redis.Options{
-		Addr: config.RedisAddress,
-	})
-	autoscaler.ctx = context.Background()
+	if err := loadRenderAPIKey(&config); err != nil {
+		return config, err
+	}
+	if err := applyScalingProfile(&config); err != nil {
+		return config, err
+	}
+	if !validAggregation(config.Aggregation) {
+		return config, fmt.Errorf("unknown aggregation %q", config.Aggregation)
+	}
+	for _, aggregation := range config.CombineAggregations {
+		if !validAggregation(aggregation) {
+			return config, fmt.Errorf("unknown aggregation %q in COMBINE_AGGREGATIONS", aggregation)
+		}
+	}
+	switch config.ScalingStrategy {
+	case "queue-depth", "cpu":
+	case "utilization":
+		if config.TargetUtilization <= 0 {
+			return config, fmt.Errorf("TARGET_UTILIZATION must be positive, got %v", config.TargetUtilization)
+		}
+	case "arrival-rate":
+		if config.AvgJobDuration <= 0 {
+			return config, fmt.Errorf("AVG_JOB_DURATION is required for the arrival-rate scaling strategy")
+		}
+	case "queue-sla":
+		if len(config.QueueTargetWaits) == 0 {
+			return config, fmt.Errorf("QUEUE_TARGET_WAITS is required for the queue-sla scaling strategy")
+		}
+		for queue, wait := range config.QueueTargetWaits {
+			_, ok := config.QueueJobDurations[queue]
+			if wait <= 0 || (!ok && config.AvgJobDuration <= 0) {
+				return config, fmt.Errorf("queue %s needs a positive target wait and a job duration", queue)
+			}
+		}
+	default:
+		return config, fmt.Errorf("unknown scaling strategy %q", config.ScalingStrategy)
+	}
+	switch config.StatsSource {
+	case "redis":
+		if config.RedisAddress == "" {
+			return config, fmt.Errorf("REDIS_ADDRESS is required")
+		}
+	case "http":
+		if config.StatsURL == "" {
+			return config, fmt.Errorf("STATS_URL is required when STATS_SOURCE is http")
+		}
+		if config.ScalingStrategy == "queue-sla" {
+			return config, fmt.Errorf("the queue-sla scaling strategy is not supported when STATS_SOURCE is http")
+		}
+		if len(config.QueueRatios) > 0 || len(config.PayloadWeightPaths) > 0 || len(config.QueueGroups) > 0 ||
+			len(config.QueuePriorities) > 0 {
+			return config, fmt.Errorf("QUEUE_RATIOS, PAYLOAD_WEIGHT_PATHS, QUEUE_GROUPS and QUEUE_PRIORITIES are not supported when STATS_SOURCE is http")
+		}
+		if config.PausedQueueKey != "" {
+			return config, fmt.Errorf("PAUSED_QUEUE_KEY is not supported when STATS_SOURCE is http")
+		}
+	default:
+		return config, fmt.Errorf("unknown stats source %q", config.StatsSource)
+	}
+	if config.PredictionHorizon == 0 {
+		config.PredictionHorizon = config.Interval
+	}
+	if config.MinInterval == 0 {
+		config.MinInterval = config.Interval
+	}
+	if config.MaxInterval == 0 {
+		config.MaxInterval = config.Interval
+	}
+	if config.DrainTimeTarget > 0 {
+		if config.AvgJobDuration <= 0 {
+			return config, fmt.Errorf("AVG_JOB_DURATION is required for DRAIN_TIME_TARGET")
+		}
+		switch config.DrainTimeCombine {
+		case "max", "min", "avg":
+		default:
+			return config, fmt.Errorf("unknown drain time combination %q", config.DrainTimeCombine)
+		}
+	}
+	if config.HistoryKey != "" {
+		if config.RedisAddress == "" {
+			return config, fmt.Errorf("HISTORY_KEY requires REDIS_ADDRESS")
+		}
+		if config.HistoryPercentile <= 0 || config.HistoryPercentile > 100 {
+			return config, fmt.Errorf("HISTORY_PERCENTILE must be in (0, 100], got %v", config.HistoryPercentile)
+		}
+	}
+	if config.DynamicDelay && config.DynamicDelayMaxBacklog <= config.DynamicDelayMinBacklog {
+		return config, fmt.Errorf("DYNAMIC_DELAY_MAX_BACKLOG must be greater than DYNAMIC_DELAY_MIN_BACKLOG")
+	}
+	if config.PriorityDecay < 0 || config.PriorityDecay > 1 {
+		return config, fmt.Errorf("PRIORITY_DECAY must be between 0 and 1, got %v", config.PriorityDecay)
+	}
+	if err := validateHeaders(config.RenderHeaders); err != nil {
+		return config, fmt.Errorf("invalid RENDER_HEADERS: %v", err)
+	}
+	if config.LeaderLockKey != "" && config.RedisAddress == "" {
+		return config, fmt.Errorf("LEADER_LOCK_KEY requires REDIS_ADDRESS")
+	}
+	if config.MinInterval > config.MaxInterval {
+		return config, fmt.Errorf("MIN_INTERVAL %s is greater than MAX_INTERVAL %s", config.MinInterval, config.MaxInterval)
+	}
+	if config.ScaleDownMode != "default" && config.ScaleDownMode != "conservative" {
+		return config, fmt.Errorf("unknown scale down mode %q", config.ScaleDownMode)
+	}
+	if config.QueueGroupMode != "sum" && config.QueueGroupMode != "max" {
+		return config, fmt.Errorf("unknown queue group mode %q", config.QueueGroupMode)
+	}
+	if config.LiveWorkerCapacity && config.RedisAddress == "" {
+		return config, fmt.Errorf("LIVE_WORKER_CAPACITY requires REDIS_ADDRESS")
+	}
+	if config.StuckJobThreshold > 0 && config.RedisAddress == "" {
+		return config, fmt.Errorf("STUCK_JOB_THRESHOLD requires REDIS_ADDRESS")
+	}
+	if config.DesiredExpression != "" {
+		if _, err := parseExpression(config.DesiredExpression); err != nil {
+			return config, fmt.Errorf("invalid DESIRED_EXPRESSION: %v", err)
+		}
+	}
+	if len(config.AllowedInstanceCounts) > 0 {
+		if config.ScaleDownMode == "conservative" {
+			return config, fmt.Errorf("ALLOWED_INSTANCE_COUNTS is not supported with the conservative scale down mode")
+		}
+		sort.Ints(config.AllowedInstanceCounts)
+		counts := config.AllowedInstanceCounts
+		if counts[0] < 0 {
+			return config, fmt.Errorf("ALLOWED_INSTANCE_COUNTS cannot be negative")
+		}
+		inBounds := false
+		for _, n := range counts {
+			if n >= config.MinInstances && n <= config.MaxInstances {
+				inBounds = true
+				break
+			}
+		}
+		if !inBounds {
+			return config, fmt.Errorf("none of ALLOWED_INSTANCE_COUNTS are between MIN_INSTANCES and MAX_INSTANCES")
+		}
+	}
+	if config.SyntheticLoad != "" {
+		if _, err := parseSyntheticLoad(config.SyntheticLoad); err != nil {
+			return config, err
+		}
+	}
+	for _, namespace := range config.ResqueNamespaces {
+		if namespace == "" {
+			return config, fmt.Errorf("RESQUE_NAMESPACES cannot contain an empty namespace")
+		}
+	}
+	if len(config.ResqueNamespaces) > 0 {
+		// the features that only read one namespace use the first
+		config.ResqueNamespace = config.ResqueNamespaces[0]
+	}
+	if config.ResqueNamespace == "" {
+		return config, fmt.Errorf("RESQUE_NAMESPACE cannot be empty")
+	}
+	return config, nil
+}
+
+// loadRenderAPIKey reads the API key from RenderAPIKeyFile when it is set.
+func loadRenderAPIKey(config *AutoscalerConfig) error {
+	if config.RenderAPIKeyFile != "" {
+		key, err := ioutil.ReadFile(config.RenderAPIKeyFile)
+		if err != nil {
+			return fmt.Errorf("unable to read render api key file: %v", err)
+		}
+		config.RenderAPIKey = strings.TrimSpace(string(key))
+	}
+	if config.RenderAPIKey == "" && config.WorkerServiceAPIKey == "" {
+		return fmt.Errorf("one of RENDER_API_KEY, RENDER_API_KEY_FILE or WORKER_SERVICE_API_KEY is required")
+	}
+	return nil
 }
 
 func main() {
-	instancesChan := make(chan int)
-	go scaleWorkersLoop(instancesChan)
-	calculateInstancesLoop(instancesChan)
+	config, err := loadConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+	setup(config)
+	if autoscaler.config().ListenAddress != "" {
+		startHTTPServer()
+	}
+	if autoscaler.config().LeaderLockKey != "" {
+		campaign()
+		go leaderLoop()
+	}
+	if autoscaler.config().StartupScaleToMin && isLeader() {
+		scaleToMinOnStartup()
+	}
+	go scaleWorkersLoop(autoscaler.scaleChan)
+	if autoscaler.config().ReconcileDrift {
+		go reconcileLoop(autoscaler.scaleChan)
+	}
+	if autoscaler.config().DryRun {
+		go observeLoop()
+	}
+	if autoscaler.config().DriftCheckInterval > 0 {
+		go driftLoop(autoscaler.config().DriftCheckInterval)
+	}
+	if autoscaler.config().WorkersEnvVar != "" {
+		go workersPerInstanceLoop(autoscaler.config().WorkersPerInstance)
+	}
+	if autoscaler.config().MetricsLogInterval > 0 {
+		go metricsLogLoop(autoscaler.config().MetricsLogInterval)
+	}
+	go reloadOnSIGHUP()
+	if autoscaler.config().TriggerChannel != "" && autoscaler.redis != nil {
+		go triggerLoop(autoscaler.scaleChan)
+	}
+	go calculateInstancesLoop(autoscaler.scaleChan)
+	waitForShutdown()
+}
+
+// scaleToMinOnStartup scales straight down to the minimum instance count if
+// the service is currently above it, rather than waiting for the sample
+// window to fill.
+func scaleToMinOnStartup() {
+	now := autoscaler.clock.Now()
+	autoscaler.mu.Lock()
+	min := minInstances(now)
+	min = allowedInstanceCount(min, min, maxInstances())
+	if min >= autoscaler.instances || observeOnly(now) {
+		autoscaler.mu.Unlock()
+		return
+	}
+	log.Infof("scaling down from %d to the minimum of %d instances on startup", autoscaler.instances, min)
+	decision := scaleDecision{From: autoscaler.instances, To: min, Reason: "startup"}
+	autoscaler.instances = min
+	autoscaler.lastScaleTime = now
+	autoscaler.mu.Unlock()
+	updateNumInstances(decision)
 }
 
-func getInstanceCount() int {
-	path := "/services/" + autoscaler.config.WorkerServiceId
-	status, resp, err := renderAPICall("GET", path, "")
-	if err != nil || status != http.StatusOK {
-		log.Error("unable to retrieve current instance count")
-		return autoscaler.config.MinInstances
+func getInstanceCount(ctx context.Context) int {
+	count, err := fetchInstanceCount(ctx)
+	if err != nil {
+		recordError("render", "unable to retrieve current instance count")
+		return autoscaler.config().MinInstances
 	}
-	count := gjson.Get(resp, "serviceDetails.numInstances").Num
 	if count > 0 {
-		return int(count)
+		return count
+	}
+	return autoscaler.config().MinInstances
+}
+
+// fetchInstanceCount returns the instance count Render reports for the worker
+// service and records when it was confirmed. It must not be called with the
+// state mutex held.
+func fetchInstanceCount(ctx context.Context) (int, error) {
+	path := "/services/" + autoscaler.config().WorkerServiceId
+	status, resp, err := renderAPICall(ctx, workerServiceAPIKey(), "GET", path, "")
+	if err != nil {
+		return 0, err
+	}
+	if status != http.StatusOK {
+		return 0, fmt.Errorf("unexpected status %d", status)
+	}
+	count := gjson.Get(resp, "serviceDetails.numInstances")
+	if !count.Exists() {
+		return 0, fmt.Errorf("response has no instance count")
+	}
+	autoscaler.mu.Lock()
+	autoscaler.instanceCountTime = autoscaler.clock.Now()
+	autoscaler.mu.Unlock()
+	return int(count.Int()), nil
+}
+
+// getCPUUsage returns the service's average CPU usage per instance over the
+// configured window, as reported by the Render metrics API.
+func getCPUUsage(ctx context.Context) (float64, error) {
+	now := time.Now()
+	path := fmt.Sprintf("/metrics/cpu?resource=%s&aggregationMethod=AVG&startTime=%s&endTime=%s",
+		autoscaler.config().WorkerServiceId,
+		now.Add(-autoscaler.config().CPUWindow).UTC().Format(time.RFC3339),
+		now.UTC().Format(time.RFC3339))
+	status, resp, err := renderAPICall(ctx, workerServiceAPIKey(), "GET", path, "")
+	if err != nil {
+		return 0, err
+	}
+	if status != http.StatusOK {
+		return 0, fmt.Errorf("unexpected status %d", status)
+	}
+	sum, count := 0.0, 0
+	gjson.Get(resp, "#.values").ForEach(func(_, values gjson.Result) bool {
+		values.ForEach(func(_, v gjson.Result) bool {
+			sum += v.Get("value").Num
+			count++
+			return true
+		})
+		return true
+	})
+	if count == 0 {
+		return 0, fmt.Errorf("no cpu data points returned")
+	}
+	return sum / float64(count), nil
+}
+
+// workerServiceAPIKey returns the API key for the account owning the worker
+// service, which may differ from the global key.
+func workerServiceAPIKey() string {
+	if autoscaler.config().WorkerServiceAPIKey != "" {
+		return autoscaler.config().WorkerServiceAPIKey
+	}
+	return autoscaler.config().RenderAPIKey
+}
+
+func renderAPICall(ctx context.Context, apiKey, method, path, body string) (int, string, error) {
+	if !autoscaler.breaker.Allow() {
+		return 0, "", errCircuitOpen
+	}
+	start := time.Now()
+	status, resp, err := doRenderAPICall(ctx, apiKey, method, path, body)
+	renderAPIDuration.Observe(time.Since(start).Seconds())
+	if err != nil || status >= http.StatusInternalServerError || status == http.StatusTooManyRequests {
+		autoscaler.breaker.Failure()
+	} else {
+		autoscaler.breaker.Success()
+	}
+	return status, resp, err
+}
+
+// validateHeaders checks that extra Render API headers are well formed and
+// don't replace the ones every request sets.
+func validateHeaders(headers map[string]string) error {
+	for name, value := range headers {
+		if name == "" || strings.ContainsAny(name, " \t\r\n()<>@,;:\\\"/[]?={}") {
+			return fmt.Errorf("invalid header name %q", name)
+		}
+		if strings.ContainsAny(value, "\r\n") {
+			return fmt.Errorf("invalid value for header %s", name)
+		}
+		switch textproto.CanonicalMIMEHeaderKey(name) {
+		case "Accept", "Authorization", "Content-Type":
+			return fmt.Errorf("header %s is set by the autoscaler", name)
+		}
 	}
-	return autoscaler.config.MinInstances
+	return nil
 }
 
-func renderAPICall(method, path, body string) (int, string, error) {
-	url := "https://api.render.com/v1" + path
+// renderClient is shared by all Render API calls so that connections, and
+// their TLS sessions, are reused between polls.
+var renderClient *http.Client
+
+func newRenderClient(config AutoscalerConfig) *http.Client {
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	return &http.Client{Transport: &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		DialContext:         dialer.DialContext,
+		ForceAttemptHTTP2:   true,
+		MaxIdleConns:        config.RenderMaxIdleConns,
+		MaxIdleConnsPerHost: config.RenderMaxIdleConns,
+		IdleConnTimeout:     config.RenderIdleConnTimeout,
+		TLSHandshakeTimeout: 10 * time.Second,
+	}}
+}
+
+func doRenderAPICall(ctx context.Context, apiKey, method, path, body string) (int, string, error) {
+	url := strings.TrimSuffix(autoscaler.config().RenderAPIBaseURL, "/") + "/" + autoscaler.config().RenderAPIVersion + path
 	var payload io.Reader
 	if body != "" {
 		payload = strings.NewReader(body)
 	}
-	req, err := http.NewRequest(method, url, payload)
+	req, err := http.NewRequestWithContext(ctx, method, url, payload)
 	if err != nil {
 		return 0, "", err
 	}
 	req.Header.Add("Accept", "application/json")
 	req.Header.Add("Content-Type", "application/json")
-	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", autoscaler.config.RenderAPIKey))
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", apiKey))
+	for name, value := range autoscaler.config().RenderHeaders {
+		req.Header.Set(name, value)
+	}
 
-	res, err := http.DefaultClient.Do(req)
+	res, err := renderClient.Do(req)
 	if err != nil {
 		return 0, "", err
 	}
 
 	defer res.Body.Close()
-	resBody, err := ioutil.ReadAll(res.Body)
+	limit := autoscaler.config().MaxResponseBytes
+	resBody, err := ioutil.ReadAll(io.LimitReader(res.Body, limit+1))
 	if err != nil {
 		return 0, "", err
 	}
+	if int64(len(resBody)) > limit {
+		return 0, "", fmt.Errorf("render api response exceeds %d bytes", limit)
+	}
 
 	return res.StatusCode, string(resBody), nil
 }
 
-func calculateInstancesLoop(c chan int) {
+func calculateInstancesLoop(c chan scaleDecision) {
+	var totalInstanceSeconds float64
+	lastCostUpdate := time.Now()
+	interval := autoscaler.config().Interval
+	prev := scaleDecision{Backlog: -1}
+	iteration := 0
 	for {
-		n := calculateDesiredInstances()
-		if n != autoscaler.instances {
-			c <- n
-			autoscaler.instances = n
-			autoscaler.lastScaleTime = time.Now()
+		start := time.Now()
+		autoscaler.mu.Lock()
+		seconds := float64(autoscaler.instances) * start.Sub(lastCostUpdate).Seconds()
+		autoscaler.mu.Unlock()
+		lastCostUpdate = start
+		totalInstanceSeconds += seconds
+		instanceSeconds.Add(seconds)
+		if autoscaler.config().InstanceHourlyCost > 0 {
+			estimatedCost.Set(totalInstanceSeconds / 3600 * autoscaler.config().InstanceHourlyCost)
+		}
+
+		// followers only measure, so that they have samples ready if they
+		// take over
+		decision, applied, ok := evaluateWithTimeout(c, isLeader())
+		counted := time.Since(start)
+		if !ok {
+			log.Warnf("abandoning evaluation that did not finish within %s", autoscaler.config().EvaluationTimeout)
+			evaluationTimeouts.Inc()
+			time.Sleep(interval)
+			continue
+		}
+		iteration++
+		if n := autoscaler.config().LogEveryNIterations; n > 0 && iteration%n == 0 && !applied {
+			log.Infof("holding %d instances for a backlog of %d jobs (%s)", decision.From, decision.Backlog, decision.Reason)
+		}
+		// blocks while a previous scale request is still in flight
+		if applied && !sendDecision(c, decision) {
+			return
+		}
+		elapsed := time.Since(start)
+		iterationDuration.Observe(elapsed.Seconds())
+		iterationPhaseDuration.WithLabelValues("count").Observe(counted.Seconds())
+		iterationPhaseDuration.WithLabelValues("handoff").Observe((elapsed - counted).Seconds())
+		interval = nextInterval(interval, prev, decision)
+		prev = decision
+		if elapsed > interval {
+			log.Warnf("evaluation took %s, longer than the %s interval (counting %s, waiting on the scale loop %s)",
+				elapsed, interval, counted, elapsed-counted)
+		}
+		time.Sleep(interval)
+	}
+}
+
+// significantChange is the relative change in backlog between evaluations
+// that makes polling speed up to MinInterval.
+const significantChange = 0.1
+
+// nextInterval returns how long to sleep before the next evaluation. It drops
+// to MinInterval while the backlog is changing or a scale is pending and
+// otherwise doubles towards MaxInterval. With the defaults both equal
+// Interval, so the interval is fixed.
+func nextInterval(interval time.Duration, prev, d scaleDecision) time.Duration {
+	min, max := autoscaler.config().MinInterval, autoscaler.config().MaxInterval
+	change := math.Abs(float64(d.Backlog - prev.Backlog))
+	base := math.Max(float64(prev.Backlog), 1)
+	if prev.Backlog < 0 || d.To != d.From || change/base > significantChange {
+		return min
+	}
+	interval *= 2
+	if interval < min {
+		return min
+	}
+	if interval > max {
+		return max
+	}
+	return interval
+}
+
+// evaluationResult is the outcome of an evaluation run by
+// evaluateWithTimeout.
+type evaluationResult struct {
+	decision scaleDecision
+	applied  bool
+}
+
+// evaluateWithTimeout runs evaluate, giving up after EvaluationTimeout, in
+// which case ok is false. An abandoned evaluation keeps running in the
+// background, but leaves the state untouched unless it finishes before the
+// deadline, and no new evaluation starts until it has returned. If it applied
+// a decision the caller never received, it sends the decision to c itself.
+func evaluateWithTimeout(c chan scaleDecision, apply bool) (d scaleDecision, applied, ok bool) {
+	timeout := autoscaler.config().EvaluationTimeout
+	if timeout <= 0 {
+		d, applied = evaluate(context.Background(), apply)
+		return d, applied, true
+	}
+	if !atomic.CompareAndSwapInt32(&autoscaler.evaluating, 0, 1) {
+		log.Warn("previous evaluation is still running")
+		return d, false, false
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	// abandoned is set once the caller gives up, after which the evaluation
+	// hands any decision it applied to the scale loop itself
+	var mu sync.Mutex
+	abandoned := false
+	done := make(chan evaluationResult, 1)
+	go func() {
+		defer atomic.StoreInt32(&autoscaler.evaluating, 0)
+		defer cancel()
+		d, applied := evaluate(ctx, apply)
+		mu.Lock()
+		if !abandoned {
+			done <- evaluationResult{d, applied}
+			mu.Unlock()
+			return
+		}
+		mu.Unlock()
+		if applied {
+			sendDecision(c, d)
+		}
+	}()
+	select {
+	case r := <-done:
+		return r.decision, r.applied, true
+	case <-ctx.Done():
+		mu.Lock()
+		defer mu.Unlock()
+		// the evaluation may have finished just as the deadline passed
+		select {
+		case r := <-done:
+			return r.decision, r.applied, true
+		default:
+		}
+		abandoned = true
+		return d, false, false
+	}
+}
+
+// scaleDecision describes a change from one instance count to another and
+// what prompted it.
+type scaleDecision struct {
+	From    int
+	To      int
+	Backlog int
+	Reason  string
+}
+
+// evaluate computes the desired instance count and returns it along with the
+// current count. If apply is true and the two differ, the desired count is
+// recorded as the new current count and applied is returned as true; the
+// caller is then responsible for sending the decision to the scale loop.
+// Nothing is recorded if ctx is done by the time the load has been measured.
+func evaluate(ctx context.Context, apply bool) (d scaleDecision, applied bool) {
+	autoscaler.evaluation.Lock()
+	defer autoscaler.evaluation.Unlock()
+	return runEvaluation(ctx, apply)
+}
+
+// runEvaluation does the work of evaluate. It must be called with the
+// evaluation mutex held.
+func runEvaluation(ctx context.Context, apply bool) (d scaleDecision, applied bool) {
+	if deployInProgress(ctx) {
+		// worker registrations and instance counts are in flux, so don't
+		// even sample them
+		autoscaler.mu.Lock()
+		defer autoscaler.mu.Unlock()
+		d = scaleDecision{From: autoscaler.instances, To: autoscaler.instances, Reason: "deploy"}
+		autoscaler.lastAverage, autoscaler.lastComputed, autoscaler.lastGate = 0, 0, "deploy"
+		gateBlocked.WithLabelValues("deploy").Inc()
+		recordDecision(d, false)
+		return d, false
+	}
+
+	// talk to redis before taking the lock so slow calls don't block readers
+	refreshInstanceCount(ctx)
+	refreshWorkerCapacity(ctx)
+	jobs, loadErr := pollLoad(ctx)
+	n, overridden := getOverride(ctx)
+	refreshHealth(ctx)
+	refreshQuota(ctx)
+	refreshChurn(ctx)
+	refreshStuckWorkers(ctx)
+	if loadErr == nil {
+		refreshHistory(ctx, adjustedBacklog(jobs))
+	}
+
+	autoscaler.mu.Lock()
+	defer autoscaler.mu.Unlock()
+	if ctx.Err() != nil {
+		d.From, d.To = autoscaler.instances, autoscaler.instances
+		return d, false
+	}
+	autoscaler.activeJobs = autoscaler.cachedActive
+	autoscaler.pendingJobs = autoscaler.cachedPending
+	if autoscaler.config().SafeScaleDown {
+		recordActiveJobs(autoscaler.activeJobs)
+	}
+	autoscaler.lastAverage, autoscaler.lastComputed, autoscaler.lastGate = 0, 0, ""
+	d.From = autoscaler.instances
+	if loadErr == nil {
+		autoscaler.loadFailures = 0
+		adjusted := adjustedBacklog(jobs)
+		backlogJobs.Set(float64(jobs))
+		adjustedBacklogJobs.Set(float64(adjusted))
+		d.To = calculateDesiredInstances(adjusted)
+		d.Backlog = jobs
+		d.Reason = "load"
+	} else {
+		d.To = failsafeInstances()
+		d.Reason = "failsafe"
+	}
+	if overridden {
+		n = baseFloor(n)
+		if !autoscaler.override {
+			log.Infof("instance override to %d is active", n)
+			if autoscaler.scaleUpFrozen {
+				log.Info("scale-up freeze reset by instance override")
+			}
+			autoscaler.scaleUpFrozen = false
+			autoscaler.consecutiveScaleUps = 0
 		}
-		time.Sleep(autoscaler.config.Interval)
+		d.To = n
+		d.Reason = "override"
+	} else if autoscaler.override {
+		log.Info("instance override lifted, resuming autoscaling")
+	}
+	autoscaler.override = overridden
+	autoscaler.lastDesired = d.To
+	desiredInstancesGauge.Set(float64(d.To))
+	applied = apply && d.To != d.From && !autoscaler.shuttingDown
+	if applied && observeOnly(autoscaler.clock.Now()) {
+		log.Debugf("observe only, not scaling from %d to %d instances (%s)", d.From, d.To, d.Reason)
+		applied = false
+	}
+	recordDecision(d, applied)
+	if !applied {
+		return d, false
 	}
+	autoscaler.instances = d.To
+	autoscaler.lastScaleTime = autoscaler.clock.Now()
+	if d.To > d.From {
+		recordScaleUp(autoscaler.lastScaleTime)
+	} else {
+		autoscaler.consecutiveScaleUps = 0
+	}
+	return d, true
 }
 
-func calculateDesiredInstances() int {
-	jobs := countActiveJobs() + countPendingJobs()
-	autoscaler.samples = append(autoscaler.samples, jobs)
+func calculateDesiredInstances(jobs int) int {
+	autoscaler.samples.Push(jobs)
+	runShadowEvaluations(jobs)
 
 	// not enough samples collected, return current instance count
-	if len(autoscaler.samples) < autoscaler.config.NumSamples {
+	if !autoscaler.samples.Full() {
+		autoscaler.lastAverage, autoscaler.lastComputed, autoscaler.lastGate = 0, autoscaler.instances, "samples"
+		gateBlocked.WithLabelValues("samples").Inc()
 		return autoscaler.instances
 	}
 
-	if len(autoscaler.samples) > autoscaler.config.NumSamples {
-		autoscaler.samples = autoscaler.samples[1:]
+	avgNumJobs := aggregateSamples()
+	idle := avgNumJobs <= float64(autoscaler.config().IdleJobThreshold)
+	if idle && !autoscaler.idle && avgNumJobs > 0 {
+		log.Infof("declaring idle with %.1f jobs at or below idle job threshold %d",
+			avgNumJobs, autoscaler.config().IdleJobThreshold)
+	}
+	autoscaler.idle = idle
+	if idle {
+		avgNumJobs = 0
+	}
+	if autoscaler.config().DrainTimeTarget > 0 {
+		avgNumJobs = combineDrainTime(avgNumJobs)
+	}
+	autoscaler.lastAverage = avgNumJobs
+	desiredWorkers := avgNumJobs / workersPerInstance()
+	if autoscaler.config().GrowthBoostFactor > 1 {
+		slope := autoscaler.samples.Slope(autoscaler.config().GrowthBoostSamples)
+		if slope > autoscaler.config().GrowthThreshold {
+			desiredWorkers *= autoscaler.config().GrowthBoostFactor
+		}
+	}
+	now := autoscaler.clock.Now()
+	// the base is managed by hand; only the instances above it are autoscaled
+	desiredInstances := autoscaler.config().BaseInstances + int(math.Ceil(desiredWorkers))
+	if transient := float64(autoscaler.transientJobs); transient > 0 && desiredInstances > autoscaler.instances {
+		// jobs in queues that only just became non-empty may not stick
+		// around, so they must not cause a scale-up on their own
+		sustained := math.Max(avgNumJobs-transient, 0) * desiredWorkers / math.Max(avgNumJobs, 1)
+		filtered := autoscaler.config().BaseInstances + int(math.Ceil(sustained))
+		if filtered < autoscaler.instances {
+			filtered = autoscaler.instances
+		}
+		if filtered < desiredInstances {
+			log.Debugf("not counting %.0f jobs in newly non-empty queues towards scaling up", transient)
+			desiredInstances = filtered
+		}
+	}
+	slo := autoscaler.config().QueueLatencySLO
+	if slo > 0 && autoscaler.maxQueueLatency > slo && desiredInstances <= autoscaler.instances {
+		log.Infof("queue latency of %s exceeds the %s slo, scaling up", autoscaler.maxQueueLatency, slo)
+		desiredInstances = autoscaler.instances + 1
+	}
+	if unavailable := unavailableInstances(desiredInstances); unavailable > 0 {
+		log.Debugf("adding %d instances for ones that aren't processing jobs", unavailable)
+		desiredInstances += unavailable
+	}
+	if autoscaler.desiredExpression != nil {
+		desiredInstances = expressionInstances(avgNumJobs, now, desiredInstances)
+	}
+	if max := maxInstances(); desiredInstances > max {
+		log.Debugf("clamping desired %d instances to maximum %d", desiredInstances, max)
+		clampedMax.Inc()
+		desiredInstances = max
+	}
+	if min := minInstances(now); desiredInstances < min {
+		log.Debugf("clamping desired %d instances to minimum %d", desiredInstances, min)
+		clampedMin.Inc()
+		desiredInstances = min
+	}
+
+	// smooth the output too, so the target doesn't flap between adjacent counts
+	autoscaler.outputs.Push(desiredInstances)
+	desiredInstances = int(math.Round(autoscaler.outputs.Average()))
+
+	if autoscaler.config().SafeScaleDown && desiredInstances < autoscaler.instances {
+		if floor := safeScaleDownFloor(); desiredInstances < floor {
+			log.Debugf("raising desired %d instances to %d to cover the recent peak of active jobs",
+				desiredInstances, floor)
+			desiredInstances = floor
+		}
+	}
+	desiredInstances = allowedInstanceCount(desiredInstances, minInstances(now), maxInstances())
+	autoscaler.lastComputed = desiredInstances
+
+	if desiredInstances == autoscaler.instances {
+		autoscaler.consecutiveScaleUps = 0
 	}
 
-	avgNumJobs := average(autoscaler.samples)
-	desiredInstances := int(math.Ceil(avgNumJobs / float64(autoscaler.config.WorkersPerInstance)))
-	if desiredInstances > autoscaler.config.MaxInstances {
-		desiredInstances = autoscaler.config.MaxInstances
+	gate := ""
+	switch {
+	case now.Before(autoscaler.startTime.Add(autoscaler.config().StartupGracePeriod)):
+		log.Debugf("startup grace period, holding %d instances instead of %d", autoscaler.instances, desiredInstances)
+		gate = "startup-grace"
+	case desiredInstances != autoscaler.instances && instanceCountStale():
+		gate = "stale-count"
+	case belowMinDelta(now, desiredInstances):
+		gate = "min-delta"
+	case desiredInstances > autoscaler.instances:
+		gate = scaleUpGate(now)
+	case desiredInstances < autoscaler.instances:
+		gate = scaleDownGate(now, desiredInstances)
+		if gate == "" && autoscaler.config().ScaleDownMode == "conservative" {
+			if target, ok := conservativeScaleDown(); ok {
+				desiredInstances = target
+			} else {
+				gate = "drain"
+			}
+		}
 	}
-	if desiredInstances < autoscaler.config.MinInstances {
-		desiredInstances = autoscaler.config.MinInstances
+	autoscaler.lastGate = gate
+	if gate != "" {
+		gateBlocked.WithLabelValues(gate).Inc()
+		return autoscaler.instances
 	}
+	if desiredInstances > autoscaler.instances {
+		gateAllowed.WithLabelValues("up").Inc()
+	} else if desiredInstances < autoscaler.instances {
+		gateAllowed.WithLabelValues("down").Inc()
+	}
+	return desiredInstances
+}
 
-	now := time.Now()
-	if desiredInstances > autoscaler.instances &&
-		now.After(autoscaler.lastScaleTime.Add(autoscaler.config.ScaleUpDelay)) {
-		return desiredInstances
+// belowMinDelta reports whether a change to desired instances is too small
+// to act on. Changes that bring an out of bounds instance count back within
+// the bounds are always acted on.
+func belowMinDelta(now time.Time, desired int) bool {
+	delta := desired - autoscaler.instances
+	if delta < 0 {
+		delta = -delta
 	}
+	if delta == 0 || delta >= autoscaler.config().MinInstanceDelta {
+		return false
+	}
+	return autoscaler.instances >= minInstances(now) && autoscaler.instances <= maxInstances()
+}
+
+// recordScaleUp counts a scale-up made at now towards
+// MaxConsecutiveScaleUps, freezing further scale-ups once there have been
+// that many in a row. It must be called with the state mutex held.
+func recordScaleUp(now time.Time) {
+	autoscaler.lastScaleUp = now
+	autoscaler.consecutiveScaleUps++
+	max := autoscaler.config().MaxConsecutiveScaleUps
+	if max > 0 && autoscaler.consecutiveScaleUps >= max && !autoscaler.scaleUpFrozen {
+		autoscaler.scaleUpFrozen = true
+		sendAlert("scaled up %d times in a row, freezing further scale-ups until an instance override is set", max)
+	}
+}
+
+// scaleUpGate returns the name of the first gate holding back a scale-up, or
+// the empty string if there is none.
+func scaleUpGate(now time.Time) string {
+	if gate := scaleUpSafetyGate(); gate != "" {
+		return gate
+	}
+	if !now.After(autoscaler.lastScaleTime.Add(scaleUpDelay(now))) {
+		return "scale-up-delay"
+	}
+	return ""
+}
 
-	if desiredInstances < autoscaler.instances &&
-		now.After(autoscaler.lastScaleTime.Add(autoscaler.config.ScaleDownDelay)) {
-		return desiredInstances
+// scaleUpSafetyGate returns the name of the first gate holding back any
+// scale-up, including those called for by triggers, or the empty string if
+// there is none.
+func scaleUpSafetyGate() string {
+	switch {
+	case autoscaler.scaleUpFrozen:
+		return "frozen"
+	case !healthyEnoughToScaleUp():
+		return "unhealthy"
+	case !stableEnoughToScaleUp():
+		return "churn"
 	}
+	return ""
+}
 
-	return autoscaler.instances
+// scaleDownGate returns the name of the first gate holding back a scale down
+// to desired instances, or the empty string if there is none.
+func scaleDownGate(now time.Time, desired int) string {
+	switch {
+	// newly added instances need time to boot before the backlog drains
+	case now.Before(autoscaler.lastScaleUp.Add(autoscaler.config().WarmupPeriod)):
+		return "warmup"
+	case !now.After(autoscaler.lastScaleTime.Add(autoscaler.config().ScaleDownDelay)):
+		return "scale-down-delay"
+	// removing an instance could kill the stuck job along with others
+	case autoscaler.stuckWorkers > 0:
+		return "stuck-job"
+	case !quietEnoughToScaleDown():
+		return "busy"
+	case inQuietHours(now):
+		if !autoscaler.quietLogged {
+			log.Infof("quiet hours, suppressing scale down from %d to %d instances",
+				autoscaler.instances, desired)
+			autoscaler.quietLogged = true
+		}
+		return "quiet-hours"
+	}
+	return ""
 }
 
-func average(xs []int) float64 {
-	sum := 0
-	for _, x := range xs {
-		sum += x
+// quietEnoughToScaleDown reports whether the pending and active job counts are
+// both within ScaleDownMaxPending and ScaleDownMaxActive, as far as those are
+// set. A count that couldn't be measured never is. It must be called with the
+// state mutex held.
+func quietEnoughToScaleDown() bool {
+	pending, active := autoscaler.pendingJobs, autoscaler.activeJobs
+	if max := autoscaler.config().ScaleDownMaxPending; max != nil && (pending < 0 || pending > int64(*max)) {
+		return false
+	}
+	if max := autoscaler.config().ScaleDownMaxActive; max != nil && (active < 0 || active > *max) {
+		return false
 	}
-	return float64(sum) / float64(len(xs))
+	return true
 }
 
-func countActiveJobs() int {
-	workers, err := autoscaler.redis.SMembers(autoscaler.ctx, "resque:workers").Result()
+// conservativeScaleDown returns one instance fewer than the current count, as
+// long as the remaining instances have room for every active job.
+func conservativeScaleDown() (int, bool) {
+	target := autoscaler.instances - 1
+	// Render may remove a running instance, and only those have capacity
+	capacity := int(float64(effectiveInstances()-1) * workersPerInstance())
+	if autoscaler.activeJobs < 0 || autoscaler.activeJobs > capacity {
+		log.Debugf("deferring scale down to %d instances, %d active jobs exceed their capacity of %d",
+			target, autoscaler.activeJobs, capacity)
+		return 0, false
+	}
+	return target, true
+}
+
+// allowedInstanceCount rounds n up to the nearest of AllowedInstanceCounts
+// between min and max, or down to the largest of them if n is above all of
+// them. If there are none, or none are between min and max, n is returned
+// unchanged.
+func allowedInstanceCount(n, min, max int) int {
+	if len(autoscaler.config().AllowedInstanceCounts) == 0 {
+		return n
+	}
+	allowed := -1
+	for _, count := range autoscaler.config().AllowedInstanceCounts {
+		if count < min || count > max {
+			continue
+		}
+		allowed = count
+		if count >= n {
+			break
+		}
+	}
+	if allowed < 0 {
+		log.Debugf("none of the allowed instance counts are between %d and %d, using %d", min, max, n)
+		return n
+	}
+	return allowed
+}
+
+// adjustedBacklog subtracts BacklogBaseline from a job count, flooring the
+// result at zero.
+func adjustedBacklog(jobs int) int {
+	jobs -= autoscaler.config().BacklogBaseline
+	if jobs < 0 {
+		return 0
+	}
+	return jobs
+}
+
+// combineDrainTime combines the job count with the equivalent job count for
+// draining it within DrainTimeTarget, i.e. the busy workers needed to finish
+// that many jobs of AvgJobDuration in time, according to DrainTimeCombine.
+func combineDrainTime(jobs float64) float64 {
+	drain := jobs * autoscaler.config().AvgJobDuration.Seconds() / autoscaler.config().DrainTimeTarget.Seconds()
+	perInstance := workersPerInstance()
+	policyDesiredInstances.WithLabelValues("ratio").Set(math.Ceil(jobs / perInstance))
+	policyDesiredInstances.WithLabelValues("drain-time").Set(math.Ceil(drain / perInstance))
+	switch autoscaler.config().DrainTimeCombine {
+	case "min":
+		return math.Min(jobs, drain)
+	case "avg":
+		return (jobs + drain) / 2
+	}
+	return math.Max(jobs, drain)
+}
+
+// scaleUpDelay returns FirstScaleUpDelay while at the minimum instance count,
+// so the first scale-up out of idle can be faster than further ones, and
+// ScaleUpDelay otherwise, shortened for large backlogs if DynamicDelay is set.
+func scaleUpDelay(now time.Time) time.Duration {
+	delay := autoscaler.config().ScaleUpDelay
+	if first := autoscaler.config().FirstScaleUpDelay; first != nil && autoscaler.instances <= minInstances(now) {
+		delay = *first
+	}
+	if autoscaler.config().DynamicDelay {
+		delay = dynamicDelay(delay, autoscaler.lastAverage)
+	}
+	return delay
+}
+
+// dynamicDelay shortens delay linearly with the backlog, from the full delay
+// at DynamicDelayMinBacklog jobs or fewer down to zero at
+// DynamicDelayMaxBacklog jobs or more.
+func dynamicDelay(delay time.Duration, jobs float64) time.Duration {
+	low, high := float64(autoscaler.config().DynamicDelayMinBacklog), float64(autoscaler.config().DynamicDelayMaxBacklog)
+	fraction := (jobs - low) / (high - low)
+	if fraction <= 0 {
+		return delay
+	}
+	if fraction >= 1 {
+		return 0
+	}
+	return time.Duration(float64(delay) * (1 - fraction))
+}
+
+// failsafeInstances is called with the state mutex held after load could not
+// be measured. It holds the current count until MaxConsecutiveFailures is
+// reached and then falls back to FailsafeInstances.
+func failsafeInstances() int {
+	autoscaler.loadFailures++
+	max := autoscaler.config().MaxConsecutiveFailures
+	if max <= 0 || autoscaler.loadFailures < max {
+		return autoscaler.instances
+	}
+	failsafe := autoscaler.config().MinInstances
+	if autoscaler.config().FailsafeInstances != nil {
+		failsafe = *autoscaler.config().FailsafeInstances
+	}
+	now := autoscaler.clock.Now()
+	failsafe = allowedInstanceCount(baseFloor(failsafe), minInstances(now), maxInstances())
+	if autoscaler.loadFailures == max && !autoscaler.probing {
+		sendAlert("load could not be measured %d times in a row, falling back to %d instances",
+			max, failsafe)
+	}
+	return failsafe
+}
+
+// healthyEnoughToScaleUp reports whether enough of the existing instances are
+// healthy for adding more to help. It alerts when that stops being the case.
+func healthyEnoughToScaleUp() bool {
+	min := autoscaler.config().MinHealthyRatio
+	unhealthy := min > 0 && autoscaler.healthyRatio < min
+	if unhealthy && !autoscaler.unhealthy && !autoscaler.probing {
+		sendAlert("only %.0f%% of instances are healthy, suppressing scale-ups until at least %.0f%% are",
+			autoscaler.healthyRatio*100, min*100)
+	} else if !unhealthy && autoscaler.unhealthy {
+		log.Info("instance health recovered, resuming scale-ups")
+	}
+	autoscaler.unhealthy = unhealthy
+	return !unhealthy
+}
+
+// inQuietHours reports whether scale-downs are disallowed at the given time.
+func inQuietHours(now time.Time) bool {
+	if autoscaler.quietHours == nil || !autoscaler.quietHours.Contains(now) {
+		autoscaler.quietLogged = false
+		return false
+	}
+	return true
+}
+
+// minInstances returns the minimum instance count in effect at the given
+// time, raised to the floor derived from historical load or to BaseInstances
+// if either is higher.
+func minInstances(now time.Time) int {
+	min := scheduledMinInstances(now)
+	floor := autoscaler.historyFloor
+	if base := autoscaler.config().BaseInstances; base > floor {
+		floor = base
+	}
+	if floor > min {
+		min = floor
+		if max := autoscaler.config().MaxInstances; min > max {
+			min = max
+		}
+	}
+	return min
+}
+
+// baseFloor raises n to BaseInstances, which are managed by hand and must
+// never be scaled away, limited to MaxInstances.
+func baseFloor(n int) int {
+	base := autoscaler.config().BaseInstances
+	if max := autoscaler.config().MaxInstances; base > max {
+		base = max
+	}
+	if n < base {
+		return base
+	}
+	return n
+}
+
+// scheduledMinInstances returns the configured minimum instance count in
+// effect at the given time.
+func scheduledMinInstances(now time.Time) int {
+	hours := autoscaler.businessHours
+	if hours == nil {
+		return autoscaler.config().MinInstances
+	}
+	if !hours.Contains(now) {
+		return autoscaler.config().OffHoursMin
+	}
+	if autoscaler.config().BusinessHoursMin != nil {
+		return *autoscaler.config().BusinessHoursMin
+	}
+	return autoscaler.config().MinInstances
+}
+
+// getOverride returns the manually pinned instance count, if one is set. The
+// override is a plain redis key whose expiry determines when autoscaling
+// resumes.
+func getOverride(ctx context.Context) (int, bool) {
+	if autoscaler.redis == nil {
+		return 0, false
+	}
+	val, err := autoscaler.redis.Get(ctx, autoscaler.config().OverrideKey).Int()
+	if err == redis.Nil {
+		return 0, false
+	}
+	if err != nil {
+		recordError("redis", "failed to read instance override from redis: %v", err)
+		return 0, false
+	}
+	return val, true
+}
+
+func setOverride(n int, ttl time.Duration) error {
+	if autoscaler.redis == nil {
+		return fmt.Errorf("overrides require REDIS_ADDRESS")
+	}
+	return autoscaler.redis.Set(autoscaler.ctx, autoscaler.config().OverrideKey, n, ttl).Err()
+}
+
+// aggregateSamples combines the samples with Aggregation or, if
+// CombineAggregations is set, takes the largest of the job counts each of
+// those aggregations yields, so that no single signal can understate the
+// load.
+func aggregateSamples() float64 {
+	if len(autoscaler.config().CombineAggregations) == 0 {
+		return aggregate(autoscaler.samples, autoscaler.config().Aggregation)
+	}
+	combined := 0.0
+	for _, aggregation := range autoscaler.config().CombineAggregations {
+		jobs := aggregate(autoscaler.samples, aggregation)
+		aggregationJobs.WithLabelValues(aggregation).Set(jobs)
+		combined = math.Max(combined, jobs)
+	}
+	return combined
+}
+
+func validAggregation(aggregation string) bool {
+	switch aggregation {
+	case "mean", "weighted-mean", "predictive", "latest":
+		return true
+	}
+	_, ok := parsePercentile(aggregation)
+	return ok
+}
+
+// parsePercentile parses percentile aggregations such as p95.
+func parsePercentile(aggregation string) (float64, bool) {
+	if !strings.HasPrefix(aggregation, "p") {
+		return 0, false
+	}
+	p, err := strconv.ParseFloat(aggregation[1:], 64)
+	if err != nil || p <= 0 || p > 100 {
+		return 0, false
+	}
+	return p, true
+}
+
+func aggregate(samples *ringBuffer, aggregation string) float64 {
+	switch aggregation {
+	case "weighted-mean":
+		return samples.WeightedMean()
+	case "predictive":
+		steps := float64(autoscaler.config().PredictionHorizon) / float64(autoscaler.config().Interval)
+		return samples.Predict(steps)
+	case "latest":
+		return float64(samples.At(samples.Len() - 1))
+	}
+	if p, ok := parsePercentile(aggregation); ok {
+		return samples.Percentile(p)
+	}
+	return samples.Average()
+}
+
+// existsBatchSize bounds the number of keys passed to a single EXISTS call.
+const existsBatchSize = 1000
+
+// countActiveJobs returns the number of jobs in progress, caching it for the
+// evaluation's gates.
+func countActiveJobs(ctx context.Context) (int, error) {
+	if autoscaler.config().StatsSource == "http" {
+		working, err := fetchStat(ctx, autoscaler.config().StatsWorkingPath)
+		if err != nil {
+			recordError("stats", "failed to retrieve working count from stats url: %v", err)
+			return 0, err
+		}
+		cacheActiveJobs(int(working))
+		return int(working), nil
+	}
+	jobs := 0
+	for _, namespace := range resqueNamespaces() {
+		n, err := countNamespaceActiveJobs(ctx, namespace)
+		if err != nil {
+			return 0, err
+		}
+		if len(autoscaler.config().ResqueNamespaces) > 1 {
+			log.Debugf("%d active jobs in namespace %s", n, namespace)
+		}
+		jobs += n
+	}
+	cacheActiveJobs(jobs)
+	return jobs, nil
+}
+
+// cacheActiveJobs records a measured active job count alongside the load.
+func cacheActiveJobs(n int) {
+	autoscaler.mu.Lock()
+	autoscaler.cachedActive = n
+	autoscaler.mu.Unlock()
+}
+
+// countNamespaceActiveJobs returns the number of jobs in progress in one
+// resque namespace.
+func countNamespaceActiveJobs(ctx context.Context, namespace string) (int, error) {
+	workers, err := autoscaler.redis.SMembers(ctx, namespacedKey(namespace, "workers")).Result()
 	if err != nil {
-		log.Error("failed to retrieve resque worker set from redis")
+		recordError("redis", "failed to retrieve resque worker set from redis")
+		return 0, err
 	}
+	// A worker's key only exists while it is processing a job, so a single
+	// EXISTS over every worker key counts the in-progress jobs without a
+	// round trip per worker.
 	jobs := 0
-	for _, worker := range workers {
-		workerKey := fmt.Sprintf("resque:worker:%s", worker)
-		_, err := autoscaler.redis.Get(autoscaler.ctx, workerKey).Result()
-		if err == nil {
-			jobs += 1
-		} else if err != redis.Nil {
-			log.Error("unexpected error when getting resque worker from redis")
+	keys := make([]string, 0, existsBatchSize)
+	for start := 0; start < len(workers); start += existsBatchSize {
+		end := start + existsBatchSize
+		if end > len(workers) {
+			end = len(workers)
+		}
+		keys = keys[:0]
+		for _, worker := range workers[start:end] {
+			keys = append(keys, namespacedKey(namespace, "worker:"+worker))
 		}
+		n, err := autoscaler.redis.Exists(ctx, keys...).Result()
+		if err != nil {
+			// a partial count would understate the load
+			recordError("redis", "unexpected error when getting resque workers from redis")
+			return 0, err
+		}
+		jobs += int(n)
 	}
-	return jobs
+	return jobs, nil
+}
+
+// pollLoad returns the load measured by sampleLoad, reusing the previous
+// measurement while it is younger than RedisPollInterval. The active and
+// pending job counts are cached along with it, counted separately only when
+// the decision needs them and sampleLoad didn't count them.
+func pollLoad(ctx context.Context) (int, error) {
+	autoscaler.mu.Lock()
+	if time.Since(autoscaler.cachedLoadTime) < autoscaler.config().RedisPollInterval {
+		jobs := autoscaler.cachedLoad
+		autoscaler.mu.Unlock()
+		return jobs, nil
+	}
+	autoscaler.cachedActive, autoscaler.cachedPending = -1, -1
+	autoscaler.mu.Unlock()
+	beginStatsSnapshot()
+	defer endStatsSnapshot()
+
+	jobs, err := sampleLoad(ctx)
+	if err != nil {
+		return jobs, err
+	}
+	autoscaler.mu.Lock()
+	active, pending := autoscaler.cachedActive, autoscaler.cachedPending
+	autoscaler.mu.Unlock()
+	// a count that fails stays at -1, which the gates treat as unknown
+	if active < 0 && needsActiveJobs() {
+		countActiveJobs(ctx)
+	}
+	if pending < 0 && needsPendingJobs() {
+		countPendingJobs(ctx)
+	}
+	autoscaler.mu.Lock()
+	autoscaler.cachedLoad = jobs
+	autoscaler.cachedLoadTime = time.Now()
+	autoscaler.mu.Unlock()
+	return jobs, nil
+}
+
+// needsPendingJobs reports whether the decision depends on the pending job
+// count.
+func needsPendingJobs() bool {
+	config := autoscaler.config()
+	return config.DesiredExpression != "" || config.ScaleDownMaxPending != nil
 }
 
-func countPendingJobs() int {
-	queues, err := autoscaler.redis.SMembers(autoscaler.ctx, "resque:queues").Result()
+// needsActiveJobs reports whether the decision depends on the active job
+// count.
+func needsActiveJobs() bool {
+	config := autoscaler.config()
+	return config.ScaleDownMode == "conservative" || config.SafeScaleDown ||
+		config.DesiredExpression != "" || config.ScaleDownMaxPending != nil || config.ScaleDownMaxActive != nil
+}
+
+// sampleLoad measures the load according to the scaling strategy, expressed
+// as a job count so that it can be averaged and scaled like one.
+func sampleLoad(ctx context.Context) (int, error) {
+	if autoscaler.synthetic != nil {
+		jobs := autoscaler.synthetic.At(autoscaler.clock.Now().Sub(autoscaler.startTime))
+		log.Debugf("synthetic load of %d jobs", jobs)
+		return jobs, nil
+	}
+	var jobs int
+	var err error
+	start := time.Now()
+	switch autoscaler.config().ScalingStrategy {
+	case "cpu":
+		jobs, err = cpuLoad(ctx)
+	case "arrival-rate":
+		jobs, err = arrivalRateLoad(ctx)
+	case "queue-sla":
+		jobs, err = queueSLALoad(ctx)
+	case "utilization":
+		jobs, err = utilizationLoad(ctx)
+	default:
+		jobs, err = countJobs(ctx)
+	}
+	if autoscaler.config().ScalingStrategy != "cpu" && autoscaler.config().StatsSource == "redis" {
+		redisPhaseDuration.Observe(time.Since(start).Seconds())
+	}
 	if err != nil {
-		log.Error("failed to retrieve resque queue set from redis")
+		return jobs, err
+	}
+	if upcoming := upcomingScheduledJobs(ctx); upcoming > 0 {
+		jobs = clampBacklog(int64(jobs) + upcoming)
 	}
+	return jobs, nil
+}
+
+// arrivalObservation is a snapshot of the counters used to estimate the job
+// arrival rate.
+type arrivalObservation struct {
+	pending   int64
+	completed int64
+	at        time.Time
+}
+
+// arrivalRateLoad estimates the job arrival rate from the change in pending
+// jobs plus the jobs completed since the previous observation, and returns
+// the number of busy workers needed to sustain it (Little's law: arrival rate
+// times average job duration). Until a previous observation exists it
+// returns the number of active jobs.
+func arrivalRateLoad(ctx context.Context) (int, error) {
+	active, err := countActiveJobs(ctx)
+	if err != nil {
+		return 0, err
+	}
+	pending, err := countPendingJobs(ctx)
+	if err != nil {
+		return 0, err
+	}
+	completed, err := countCompletedJobs(ctx)
+	if err != nil {
+		return 0, err
+	}
+	obs := &arrivalObservation{pending: pending, completed: completed, at: time.Now()}
+
+	autoscaler.mu.Lock()
+	prev := autoscaler.lastArrivals
+	autoscaler.lastArrivals = obs
+	autoscaler.mu.Unlock()
+
+	if prev == nil {
+		return active, nil
+	}
+	elapsed := obs.at.Sub(prev.at).Seconds()
+	if elapsed <= 0 {
+		return active, nil
+	}
+	arrivals := (obs.pending - prev.pending) + (obs.completed - prev.completed)
+	if arrivals < 0 {
+		arrivals = 0
+	}
+	rate := float64(arrivals) / elapsed
+	return clampBacklog(int64(math.Ceil(rate * autoscaler.config().AvgJobDuration.Seconds()))), nil
+}
+
+// countCompletedJobs returns the total number of jobs resque has finished,
+// successfully or not.
+func countCompletedJobs(ctx context.Context) (int64, error) {
+	if autoscaler.config().StatsSource == "http" {
+		processed, err := fetchStat(ctx, autoscaler.config().StatsProcessedPath)
+		if err != nil {
+			recordError("stats", "failed to retrieve processed count from stats url: %v", err)
+			return 0, err
+		}
+		failed, err := fetchStat(ctx, autoscaler.config().StatsFailedPath)
+		if err != nil {
+			recordError("stats", "failed to retrieve failed count from stats url: %v", err)
+			return 0, err
+		}
+		return processed + failed, nil
+	}
+	var total int64
+	for _, namespace := range resqueNamespaces() {
+		for _, key := range []string{namespacedKey(namespace, "stat:processed"), namespacedKey(namespace, "stat:failed")} {
+			n, err := autoscaler.redis.Get(ctx, key).Int64()
+			if err != nil && err != redis.Nil {
+				recordError("redis", "failed to retrieve %s from redis", key)
+				return 0, err
+			}
+			total += n
+		}
+	}
+	return total, nil
+}
+
+// cpuLoad returns the number of jobs equivalent to the instance count that
+// would bring average CPU usage to the target.
+func cpuLoad(ctx context.Context) (int, error) {
+	autoscaler.mu.Lock()
+	instances := effectiveInstances()
+	autoscaler.mu.Unlock()
+
+	usage, err := getCPUUsage(ctx)
+	if err != nil {
+		recordError("render", "failed to retrieve cpu usage from render: %v", err)
+		return 0, err
+	}
+	desired := float64(instances) * usage / autoscaler.config().CPUTarget
+	return int(math.Ceil(desired * workersPerInstance())), nil
+}
+
+// utilizationLoad returns the number of jobs equivalent to the instance count
+// that would bring the ratio of pending jobs to worker slots to the target.
+func utilizationLoad(ctx context.Context) (int, error) {
+	pending, err := countPendingJobs(ctx)
+	if err != nil {
+		return 0, err
+	}
+	autoscaler.mu.Lock()
+	instances := effectiveInstances()
+	autoscaler.mu.Unlock()
+
+	perInstance := workersPerInstance()
+	if slots := float64(instances) * perInstance; slots > 0 {
+		utilization.Set(float64(pending) / slots)
+	}
+	// the target-tracking count current * utilization / target reduces to
+	// pending / target slots, which also scales up from zero instances
+	return clampBacklog(int64(math.Ceil(float64(pending) / autoscaler.config().TargetUtilization))), nil
+}
+
+// countJobs returns the number of unfinished jobs. When per-queue ratios or
+// queue groups are configured, the instances each of them needs are
+// converted to the equivalent number of jobs at workersPerInstance, so that a
+// queue or group with ratio r needs one instance per r jobs.
+func countJobs(ctx context.Context) (int, error) {
+	active, err := countActiveJobs(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if len(autoscaler.config().QueueRatios) == 0 && len(autoscaler.queueGroups) == 0 &&
+		len(autoscaler.config().QueuePriorities) == 0 {
+		pending, err := countPendingJobs(ctx)
+		return clampBacklog(int64(active) + pending), err
+	}
+	depths, err := queueDepths(ctx)
+	if err != nil {
+		return 0, err
+	}
+	perInstance := workersPerInstance()
+	unratioed := int64(active)
+	instances := 0.0
+	groupDepths := make([]int64, len(autoscaler.queueGroups))
+	for queue, depth := range depths {
+		depth = int64(math.Ceil(float64(depth) * priorityWeight(queue)))
+		if group := queueGroupFor(queue); group >= 0 {
+			groupDepths[group] += depth
+			continue
+		}
+		ratio, ok := autoscaler.config().QueueRatios[queue]
+		if !ok || ratio <= 0 {
+			unratioed += depth
+			continue
+		}
+		instances += math.Ceil(float64(depth) / ratio)
+	}
+	instances += math.Ceil(float64(unratioed) / perInstance)
+	for i, group := range autoscaler.queueGroups {
+		needed := math.Ceil(float64(groupDepths[i]) / group.ratio)
+		if autoscaler.config().QueueGroupMode == "max" {
+			instances = math.Max(instances, needed)
+		} else {
+			instances += needed
+		}
+	}
+	return clampBacklog(int64(instances * perInstance)), nil
+}
+
+// priorityWeight returns how much a queue's depth counts towards the load.
+// Workers take jobs from queues in QueuePriorities order, so each queue's
+// jobs count PriorityDecay times as much as those of the queue before it.
+// Queues not in the list come after all of those in it.
+func priorityWeight(queue string) float64 {
+	priorities := autoscaler.config().QueuePriorities
+	if len(priorities) == 0 {
+		return 1
+	}
+	position := len(priorities)
+	for i, q := range priorities {
+		if q == queue {
+			position = i
+			break
+		}
+	}
+	return math.Pow(autoscaler.config().PriorityDecay, float64(position))
+}
+
+// clampBacklog limits a job count to MaxBacklog so that it converts safely to
+// an int on every platform.
+func clampBacklog(jobs int64) int {
+	max := autoscaler.config().MaxBacklog
+	if jobs <= max {
+		if atomic.SwapInt32(&autoscaler.backlogClamped, 0) == 1 {
+			log.Infof("backlog of %d jobs is back under the %d job maximum", jobs, max)
+		}
+		return int(jobs)
+	}
+	if atomic.SwapInt32(&autoscaler.backlogClamped, 1) == 0 {
+		log.Warnf("clamping backlog of %d jobs to the %d job maximum", jobs, max)
+	}
+	return int(max)
+}
+
+func countPendingJobs(ctx context.Context) (int64, error) {
+	if autoscaler.config().StatsSource == "http" {
+		pending, err := fetchStat(ctx, autoscaler.config().StatsPendingPath)
+		if err != nil {
+			recordError("stats", "failed to retrieve pending count from stats url: %v", err)
+			return pending, err
+		}
+		autoscaler.mu.Lock()
+		autoscaler.cachedPending = pending
+		autoscaler.mu.Unlock()
+		return pending, nil
+	}
+	depths, err := queueDepths(ctx)
 	var jobs int64
-	for _, queue := range queues {
-		queueKey := fmt.Sprintf("resque:queue:%s", queue)
-		len, err := autoscaler.redis.LLen(autoscaler.ctx, queueKey).Result()
+	for _, depth := range depths {
+		jobs += depth
+	}
+	return jobs, err
+}
+
+func namespacedKey(namespace, key string) string {
+	return namespace + ":" + key
+}
+
+// resqueNamespaces returns the namespaces whose jobs count towards the load.
+func resqueNamespaces() []string {
+	if len(autoscaler.config().ResqueNamespaces) > 0 {
+		return autoscaler.config().ResqueNamespaces
+	}
+	return []string{autoscaler.config().ResqueNamespace}
+}
+
+// registeredWorkers returns the workers registered with resque in each
+// namespace, keyed by namespace.
+func registeredWorkers(ctx context.Context) (map[string][]string, error) {
+	workers := make(map[string][]string)
+	for _, namespace := range resqueNamespaces() {
+		members, err := autoscaler.redis.SMembers(ctx, namespacedKey(namespace, "workers")).Result()
 		if err != nil {
-			log.Error("unexpected error when getting resque queue length")
+			return nil, err
 		}
-		jobs += len
+		workers[namespace] = members
 	}
-	return int(jobs)
+	return workers, nil
 }
 
-func scaleWorkersLoop(c chan int) {
+// queueNames returns the members of a namespace's resque queue set. With a
+// scan count configured the set is read incrementally with SSCAN rather than
+// in one blocking SMEMBERS call.
+func queueNames(ctx context.Context, namespace string) ([]string, error) {
+	key := namespacedKey(namespace, "queues")
+	count := autoscaler.config().QueueScanCount
+	if count <= 0 {
+		return autoscaler.redis.SMembers(ctx, key).Result()
+	}
+	var queues []string
+	var cursor uint64
+	for {
+		members, next, err := autoscaler.redis.SScan(ctx, key, cursor, "", count).Result()
+		if err != nil {
+			return queues, err
+		}
+		queues = append(queues, members...)
+		if next == 0 {
+			return queues, nil
+		}
+		cursor = next
+	}
+}
+
+// queueDepths returns the number of enqueued jobs in each unpaused resque
+// queue, summed across namespaces for queues of the same name, and caches
+// their total as the pending job count. If any queue's length can't be read,
+// even after a retry, it returns the depths of the others along with an
+// error.
+func queueDepths(ctx context.Context) (map[string]int64, error) {
+	multiple := len(autoscaler.config().ResqueNamespaces) > 1
+	depths := make(map[string]int64)
+	var maxLatency time.Duration
+	var paused, failed []string
+	total := 0
+	for _, namespace := range resqueNamespaces() {
+		queues, err := queueNames(ctx, namespace)
+		if err != nil {
+			recordError("redis", "failed to retrieve resque queue set from redis")
+			return nil, err
+		}
+		queues, pausedHere := unpausedQueues(ctx, namespace, queues)
+		total += len(queues)
+		var namespaceJobs int64
+		for _, queue := range pausedHere {
+			if multiple {
+				queue = namespace + ":" + queue
+			}
+			paused = append(paused, queue)
+		}
+		for _, queue := range queues {
+			queueKey := namespacedKey(namespace, "queue:"+queue)
+			keyType, len, err := queueLength(ctx, queueKey)
+			if err != nil {
+				// retry once, in case the failure was transient
+				keyType, len, err = queueLength(ctx, queueKey)
+			}
+			if err != nil {
+				if multiple {
+					queue = namespace + ":" + queue
+				}
+				failed = append(failed, queue)
+				continue
+			}
+			if path, ok := autoscaler.config().PayloadWeightPaths[queue]; ok && keyType == "list" && len > 0 {
+				len = weightedDepth(ctx, queueKey, path, len)
+			}
+			if autoscaler.config().EnqueuedAtPath != "" && keyType == "list" && len > 0 {
+				if latency, ok := headLatency(ctx, queueKey); ok {
+					queueLatency.WithLabelValues(namespace, queue).Set(latency.Seconds())
+					if latency > maxLatency {
+						maxLatency = latency
+					}
+				}
+			}
+			depths[queue] += len
+			namespaceJobs += len
+		}
+		if multiple {
+			log.Debugf("%d enqueued jobs in namespace %s", namespaceJobs, namespace)
+		}
+	}
+	logPausedQueues(paused)
+	autoscaler.mu.Lock()
+	autoscaler.maxQueueLatency = maxLatency
+	trackSustainedQueues(depths)
+	if len(failed) == 0 {
+		var pending int64
+		for _, depth := range depths {
+			pending += depth
+		}
+		autoscaler.cachedPending = pending
+	}
+	autoscaler.mu.Unlock()
+	if len(failed) > 0 {
+		// a partial count would understate the load and could scale down
+		err := fmt.Errorf("failed to get the length of %d of %d resque queues: %s",
+			len(failed), total, strings.Join(failed, ", "))
+		recordError("redis", "%v", err)
+		return depths, err
+	}
+	return depths, nil
+}
+
+// queueLength returns the redis type of a queue key and its length, using
+// the length command appropriate to the type. Types are cached per key to
+// save a round trip on later calls.
+func queueLength(ctx context.Context, queueKey string) (string, int64, error) {
+	keyType := "list"
+	if cached, ok := autoscaler.queueTypes.Load(queueKey); ok {
+		keyType = cached.(string)
+	} else {
+		t, err := autoscaler.redis.Type(ctx, queueKey).Result()
+		if err != nil {
+			return "", 0, err
+		}
+		if t == "none" {
+			// the queue is empty; its type is unknown until it gets a job
+			return t, 0, nil
+		}
+		keyType = t
+		autoscaler.queueTypes.Store(queueKey, keyType)
+	}
+
+	var len int64
+	var err error
+	switch keyType {
+	case "zset":
+		len, err = autoscaler.redis.ZCard(ctx, queueKey).Result()
+	case "stream":
+		len, err = autoscaler.redis.XLen(ctx, queueKey).Result()
+	case "list":
+		len, err = autoscaler.redis.LLen(ctx, queueKey).Result()
+	default:
+		err = fmt.Errorf("unsupported queue type %s", keyType)
+	}
+	if err != nil {
+		// the key may have been recreated with a different type
+		autoscaler.queueTypes.Delete(queueKey)
+	}
+	return keyType, len, err
+}
+
+// weightedDepth estimates the total work in a queue by averaging a numeric
+// payload field over the first PayloadSampleSize jobs and extrapolating to the
+// full queue length. It falls back to the plain length if no sampled job
+// carries the field.
+func weightedDepth(ctx context.Context, queueKey, path string, length int64) int64 {
+	payloads, err := autoscaler.redis.LRange(ctx, queueKey, 0, autoscaler.config().PayloadSampleSize-1).Result()
+	if err != nil {
+		recordError("redis", "unexpected error when sampling resque queue payloads")
+		return length
+	}
+	sum, count := 0.0, 0
+	for _, payload := range payloads {
+		weight := gjson.Get(payload, path)
+		if weight.Type != gjson.Number {
+			continue
+		}
+		sum += weight.Num
+		count++
+	}
+	if count == 0 {
+		return length
+	}
+	return int64(math.Ceil(sum / float64(count) * float64(length)))
+}
+
+func scaleWorkersLoop(c chan scaleDecision) {
+	defer close(autoscaler.scaleLoopDone)
 	for {
 		select {
-		case desiredInstances := <-c:
-			updateNumInstances(desiredInstances)
+		case decision := <-c:
+			updateNumInstances(decision)
+		case <-autoscaler.ctx.Done():
+			return
 		}
 	}
 }
 
-func updateNumInstances(n int) {
+// sendDecision hands d to the scale loop, returning false without sending it
+// if the autoscaler shuts down first, since the scale loop may have exited.
+func sendDecision(c chan scaleDecision, d scaleDecision) bool {
+	select {
+	case c <- d:
+		return true
+	case <-autoscaler.ctx.Done():
+		return false
+	}
+}
+
+func updateNumInstances(d scaleDecision) {
+	n := d.To
+	if autoscaler.config().DryRun {
+		log.Infof("dry run, not scaling to %d instances", n)
+		return
+	}
 	log.Infof("scaling to %d instances", n)
 
-	path := fmt.Sprintf("/services/%s/scale", autoscaler.config.WorkerServiceId)
+	// a scale that has started is finished even while shutting down, so that
+	// the shutdown scale lands after it
+	ctx := context.Background()
+	path := fmt.Sprintf("/services/%s/scale", autoscaler.config().WorkerServiceId)
 	body := fmt.Sprintf("{\"numInstances\": %d}", n)
-	status, _, err := renderAPICall("POST", path, body)
+	start := time.Now()
+	status, resp, err := renderAPICall(ctx, workerServiceAPIKey(), "POST", path, body)
+	renderPhaseDuration.Observe(time.Since(start).Seconds())
+	if err == errCircuitOpen {
+		if autoscaler.breaker.ShouldLog() {
+			log.Warnf("render api circuit breaker is open, skipping scale to %d instances", n)
+		}
+		return
+	}
 	if err != nil || status != http.StatusAccepted {
-		log.Errorf("failed to scale to %d instances", n)
+		recordError("scale", "failed to scale to %d instances", n)
+		return
+	}
+	if id := gjson.Get(resp, "id").String(); id != "" {
+		log.WithFields(log.Fields{
+			"id":     id,
+			"status": gjson.Get(resp, "status").String(),
+		}).Infof("render accepted scale to %d instances", n)
+	}
+	autoscaler.mu.Lock()
+	autoscaler.lastSuccessfulScaleTime = autoscaler.clock.Now()
+	// render accepting the scale confirms the count as much as a poll would
+	autoscaler.instanceCountTime = autoscaler.lastSuccessfulScaleTime
+	autoscaler.mu.Unlock()
+	writeAuditEntry(d)
+	reportScaleEvent(ctx, d)
+}
+
+// recordError logs an error, counts it by kind (redis, render, stats, parse
+// or scale) and remembers it for the status endpoint. It takes the state
+// mutex, so it must not be called while holding it.
+func recordError(kind, format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	log.WithField("kind", kind).Error(msg)
+	errorsTotal.WithLabelValues(kind).Inc()
+	autoscaler.mu.Lock()
+	defer autoscaler.mu.Unlock()
+	autoscaler.lastError = msg
+	autoscaler.lastErrorTime = time.Now()
+	if autoscaler.errorCounts == nil {
+		autoscaler.errorCounts = make(map[string]int)
 	}
+	autoscaler.errorCounts[kind]++
 }
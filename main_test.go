@@ -0,0 +1,605 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// fakeClock is a clock that only moves when advanced.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// fakeRender mimics the parts of the Render API the autoscaler uses: reading
+// the worker service's instance count and scaling it.
+type fakeRender struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	instances int
+	scales    []int
+}
+
+func newFakeRender(t testing.TB, instances int) *fakeRender {
+	f := &fakeRender{instances: instances}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serveHTTP))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeRender) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch {
+	case r.Method == "GET" && r.URL.Path == "/v1/services/srv-test":
+		fmt.Fprintf(w, `{"id": "srv-test", "serviceDetails": {"numInstances": %d}}`, f.instances)
+	case r.Method == "POST" && r.URL.Path == "/v1/services/srv-test/scale":
+		var body struct {
+			NumInstances int `json:"numInstances"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.instances = body.NumInstances
+		f.scales = append(f.scales, body.NumInstances)
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// Scales returns the instance counts the service was scaled to, in order.
+func (f *fakeRender) Scales() []int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]int(nil), f.scales...)
+}
+
+// testEnv is a miniredis server and a fake Render API for the autoscaler to
+// talk to.
+type testEnv struct {
+	redis  *miniredis.Miniredis
+	render *fakeRender
+	clock  *fakeClock
+}
+
+// setupTest points the autoscaler at a fresh miniredis and fake Render API
+// reporting the given instance count, with env applied on top of the
+// settings those need, and drives it with a fake clock.
+func setupTest(t testing.TB, instances int, env map[string]string) *testEnv {
+	e := &testEnv{
+		redis:  miniredis.RunT(t),
+		render: newFakeRender(t, instances),
+		clock:  &fakeClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)},
+	}
+	t.Setenv("RENDER_API_KEY", "test-key")
+	t.Setenv("WORKER_SERVICE_ID", "srv-test")
+	t.Setenv("RENDER_API_BASE_URL", e.render.URL)
+	t.Setenv("REDIS_ADDRESS", e.redis.Addr())
+	t.Setenv("LOG_LEVEL", "warn")
+	for name, value := range env {
+		t.Setenv(name, value)
+	}
+	config, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	setup(config)
+	t.Cleanup(func() {
+		autoscaler.cancel()
+		autoscaler.redis.Close()
+	})
+	autoscaler.clock = e.clock
+	autoscaler.startTime = e.clock.Now()
+	autoscaler.lastScaleTime = autoscaler.startTime
+	return e
+}
+
+// startScaleLoop runs the scale loop until the test ends.
+func (e *testEnv) startScaleLoop(t testing.TB) {
+	a := autoscaler
+	go scaleWorkersLoop(a.scaleChan)
+	t.Cleanup(func() {
+		a.cancel()
+		<-a.scaleLoopDone
+	})
+}
+
+// setQueues replaces the queues of the default resque namespace with ones
+// holding the given number of pending jobs.
+func (e *testEnv) setQueues(t testing.TB, depths map[string]int) {
+	for _, queue := range e.redis.Keys() {
+		if strings.HasPrefix(queue, "resque:queue:") || queue == "resque:queues" {
+			e.redis.Del(queue)
+		}
+	}
+	for queue, depth := range depths {
+		if _, err := e.redis.SetAdd("resque:queues", queue); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < depth; i++ {
+			if _, err := e.redis.Push("resque:queue:"+queue, `{"class":"Job","args":[]}`); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+// setWorkers registers the given number of workers, the first busy of which
+// are processing a job.
+func (e *testEnv) setWorkers(t testing.TB, workers, busy int) {
+	for _, key := range e.redis.Keys() {
+		if strings.HasPrefix(key, "resque:worker") {
+			e.redis.Del(key)
+		}
+	}
+	for i := 0; i < workers; i++ {
+		name := fmt.Sprintf("host-%d:%d:default", i, i)
+		if _, err := e.redis.SetAdd("resque:workers", name); err != nil {
+			t.Fatal(err)
+		}
+		if i < busy {
+			if err := e.redis.Set("resque:worker:"+name, `{"queue":"default"}`); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestClampBacklog(t *testing.T) {
+	autoscaler = &Autoscaler{}
+	autoscaler.setConfig(AutoscalerConfig{MaxBacklog: 100})
+	for _, tt := range []struct {
+		jobs    int64
+		want    int
+		clamped bool
+	}{
+		{50, 50, false},
+		{100, 100, false},
+		{101, 100, true},
+		{1 << 40, 100, true},
+		{99, 99, false},
+	} {
+		if got := clampBacklog(tt.jobs); got != tt.want {
+			t.Errorf("clampBacklog(%d) = %d, want %d", tt.jobs, got, tt.want)
+		}
+		if clamped := autoscaler.backlogClamped == 1; clamped != tt.clamped {
+			t.Errorf("after clampBacklog(%d): clamped = %v, want %v", tt.jobs, clamped, tt.clamped)
+		}
+	}
+}
+
+func TestCountJobsClampsBacklog(t *testing.T) {
+	e := setupTest(t, 1, map[string]string{"MAX_BACKLOG": "100"})
+	e.setQueues(t, map[string]int{"default": 80, "mailers": 70})
+	e.setWorkers(t, 10, 10)
+	jobs, err := countJobs(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if jobs != 100 {
+		t.Errorf("countJobs() = %d for 160 jobs, want the 100 job maximum", jobs)
+	}
+}
+
+// desiredAt advances the clock and returns what calculateDesiredInstances
+// decides for the given backlog, recording it as a scale if it changes the
+// instance count.
+func (e *testEnv) desiredAt(advance time.Duration, jobs int) int {
+	e.clock.Advance(advance)
+	autoscaler.mu.Lock()
+	defer autoscaler.mu.Unlock()
+	desired := calculateDesiredInstances(jobs)
+	if desired != autoscaler.instances {
+		if desired > autoscaler.instances {
+			autoscaler.lastScaleUp = e.clock.Now()
+		}
+		autoscaler.instances = desired
+		autoscaler.lastScaleTime = e.clock.Now()
+	}
+	return desired
+}
+
+func TestScaleDelays(t *testing.T) {
+	e := setupTest(t, 2, map[string]string{
+		"MIN_INSTANCES":    "1",
+		"SCALE_UP_DELAY":   "1m",
+		"SCALE_DOWN_DELAY": "10m",
+	})
+	for _, step := range []struct {
+		advance time.Duration
+		jobs    int
+		want    int
+	}{
+		// the delays run from startup
+		{0, 5, 2},
+		{time.Minute, 5, 2},
+		{time.Second, 5, 5},
+		{30 * time.Second, 8, 5},
+		{31 * time.Second, 8, 8},
+		// a scale-up restarts the scale-down delay too
+		{9 * time.Minute, 1, 8},
+		{time.Minute, 1, 8},
+		{time.Second, 1, 1},
+		{time.Minute, 3, 1},
+		{time.Second, 3, 3},
+	} {
+		if got := e.desiredAt(step.advance, step.jobs); got != step.want {
+			t.Errorf("at %s with %d jobs: desired %d instances, want %d",
+				e.clock.Now().Sub(autoscaler.startTime), step.jobs, got, step.want)
+		}
+	}
+}
+
+func TestFirstScaleUpDelayAndWarmup(t *testing.T) {
+	e := setupTest(t, 1, map[string]string{
+		"MIN_INSTANCES":        "1",
+		"SCALE_UP_DELAY":       "5m",
+		"FIRST_SCALE_UP_DELAY": "10s",
+		"SCALE_DOWN_DELAY":     "1m",
+		"WARMUP_PERIOD":        "3m",
+	})
+	for _, step := range []struct {
+		advance time.Duration
+		jobs    int
+		want    int
+	}{
+		// scaling out of the minimum only waits for FIRST_SCALE_UP_DELAY
+		{10 * time.Second, 4, 1},
+		{time.Second, 4, 4},
+		// above the minimum the full delay applies
+		{time.Minute, 6, 4},
+		// the scale-down delay has passed, but not the warmup period
+		{90 * time.Second, 1, 4},
+		{time.Minute, 1, 1},
+	} {
+		if got := e.desiredAt(step.advance, step.jobs); got != step.want {
+			t.Errorf("at %s with %d jobs: desired %d instances, want %d",
+				e.clock.Now().Sub(autoscaler.startTime), step.jobs, got, step.want)
+		}
+	}
+}
+
+func TestRenderClientReusesConnections(t *testing.T) {
+	setupTest(t, 1, nil)
+	var mu sync.Mutex
+	conns := 0
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"serviceDetails": {"numInstances": 3}}`)
+	}))
+	srv.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			mu.Lock()
+			conns++
+			mu.Unlock()
+		}
+	}
+	srv.Start()
+	defer srv.Close()
+	config := *autoscaler.config()
+	config.RenderAPIBaseURL = srv.URL
+	autoscaler.setConfig(config)
+
+	for i := 0; i < 5; i++ {
+		if n, err := fetchInstanceCount(context.Background()); err != nil || n != 3 {
+			t.Fatalf("fetchInstanceCount() = %d, %v, want 3", n, err)
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if conns != 1 {
+		t.Errorf("5 requests opened %d connections, want 1", conns)
+	}
+}
+
+func TestBaseInstancesFloor(t *testing.T) {
+	e := setupTest(t, 5, map[string]string{
+		"MIN_INSTANCES":      "1",
+		"BASE_INSTANCES":     "3",
+		"SCALE_DOWN_DELAY":   "1m",
+		"DESIRED_EXPRESSION": "1",
+	})
+	if got := minInstances(e.clock.Now()); got != 3 {
+		t.Errorf("minInstances() = %d, want the base of 3", got)
+	}
+	if got := e.desiredAt(2*time.Minute, 0); got != 3 {
+		t.Errorf("desired %d instances for an expression of 1, want the base of 3", got)
+	}
+
+	if err := setOverride(1, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if d, _ := evaluate(context.Background(), false); d.To != 3 {
+		t.Errorf("desired %d instances for an override of 1, want the base of 3", d.To)
+	}
+}
+
+func TestBaseInstancesFailsafeFloor(t *testing.T) {
+	e := setupTest(t, 5, map[string]string{
+		"MIN_INSTANCES":            "1",
+		"BASE_INSTANCES":           "3",
+		"MAX_CONSECUTIVE_FAILURES": "1",
+		"FAILSAFE_INSTANCES":       "2",
+	})
+	e.redis.Close()
+	if d, _ := evaluate(context.Background(), false); d.To != 3 || d.Reason != "failsafe" {
+		t.Errorf("failsafe desired %d instances (%s), want the base of 3", d.To, d.Reason)
+	}
+}
+
+func TestPartialQueueFailure(t *testing.T) {
+	e := setupTest(t, 5, map[string]string{
+		"MIN_INSTANCES":    "1",
+		"SCALE_DOWN_DELAY": "0s",
+	})
+	e.setQueues(t, map[string]int{"default": 2})
+	// a queue whose key can't be measured
+	if _, err := e.redis.SetAdd("resque:queues", "broken"); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.redis.Set("resque:queue:broken", "not a queue"); err != nil {
+		t.Fatal(err)
+	}
+
+	jobs, err := countPendingJobs(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "1 of 2 resque queues: broken") {
+		t.Errorf("countPendingJobs() error = %v, want one naming the broken queue", err)
+	}
+	if jobs != 2 {
+		t.Errorf("countPendingJobs() = %d, want the 2 jobs of the queue that could be measured", jobs)
+	}
+
+	e.clock.Advance(time.Minute)
+	if d, _ := evaluate(context.Background(), true); d.To != 5 || d.Reason != "failsafe" {
+		t.Errorf("desired %d instances (%s) with a queue unmeasured, want 5 held", d.To, d.Reason)
+	}
+}
+
+func TestEvaluationContextCancelsCalls(t *testing.T) {
+	setupTest(t, 2, nil)
+	hung := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	t.Cleanup(hung.Close)
+	c := *autoscaler.config()
+	c.RenderAPIBaseURL = hung.URL
+	autoscaler.setConfig(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := fetchInstanceCount(ctx); err == nil {
+		t.Error("fetchInstanceCount() against a hung render succeeded, want an error")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("fetchInstanceCount() took %v, want it cancelled by the 100ms deadline", elapsed)
+	}
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := countJobs(cancelled); err == nil {
+		t.Error("countJobs() with a cancelled context succeeded, want an error")
+	}
+}
+
+func TestAllowedInstanceCountsWithinBounds(t *testing.T) {
+	t.Setenv("RENDER_API_KEY", "test-key")
+	t.Setenv("WORKER_SERVICE_ID", "srv-test")
+	t.Setenv("REDIS_ADDRESS", "localhost:6379")
+	t.Setenv("MIN_INSTANCES", "2")
+	t.Setenv("MAX_INSTANCES", "50")
+	for _, tt := range []struct {
+		counts string
+		valid  bool
+	}{
+		{"1,100", false},
+		{"1", false},
+		{"60,100", false},
+		{"1,10,100", true},
+		{"2", true},
+		{"50", true},
+	} {
+		t.Setenv("ALLOWED_INSTANCE_COUNTS", tt.counts)
+		if _, err := loadConfig(); (err == nil) != tt.valid {
+			t.Errorf("ALLOWED_INSTANCE_COUNTS=%s: loadConfig() error = %v, want valid %v", tt.counts, err, tt.valid)
+		}
+	}
+}
+
+func TestScaleDownMaxActive(t *testing.T) {
+	e := setupTest(t, 4, map[string]string{
+		"MIN_INSTANCES":         "1",
+		"WORKERS_PER_INSTANCE":  "5",
+		"SCALE_DOWN_DELAY":      "0s",
+		"SCALE_DOWN_MAX_ACTIVE": "2",
+	})
+
+	// the queues have drained but the workers are still busy
+	e.setQueues(t, map[string]int{"default": 1})
+	e.setWorkers(t, 10, 10)
+	e.clock.Advance(time.Minute)
+	if d, _ := evaluate(context.Background(), true); d.To != 4 || autoscaler.lastGate != "busy" {
+		t.Errorf("desired %d instances (gate %q) with 10 active jobs, want 4 held by the busy gate", d.To, autoscaler.lastGate)
+	}
+
+	e.setWorkers(t, 10, 1)
+	e.clock.Advance(time.Minute)
+	if d, _ := evaluate(context.Background(), true); d.To != 1 || autoscaler.lastGate != "" {
+		t.Errorf("desired %d instances (gate %q) with 1 active job, want 1", d.To, autoscaler.lastGate)
+	}
+}
+
+func TestScaleDownCountsReuseLoadMeasurement(t *testing.T) {
+	e := setupTest(t, 4, map[string]string{
+		"MIN_INSTANCES":          "1",
+		"SCALE_DOWN_MAX_PENDING": "5",
+		"SCALE_DOWN_MAX_ACTIVE":  "5",
+		"REDIS_POLL_INTERVAL":    "1h",
+	})
+	e.setQueues(t, map[string]int{"default": 3})
+	e.setWorkers(t, 4, 2)
+	evaluate(context.Background(), true)
+	if autoscaler.activeJobs != 2 || autoscaler.pendingJobs != 3 {
+		t.Fatalf("active, pending = %d, %d, want the 2 and 3 measured", autoscaler.activeJobs, autoscaler.pendingJobs)
+	}
+
+	// within the poll interval the counts come from the cached measurement
+	e.setQueues(t, map[string]int{"default": 30})
+	e.setWorkers(t, 4, 4)
+	evaluate(context.Background(), true)
+	if autoscaler.activeJobs != 2 || autoscaler.pendingJobs != 3 {
+		t.Errorf("active, pending = %d, %d within the poll interval, want the cached 2 and 3", autoscaler.activeJobs, autoscaler.pendingJobs)
+	}
+}
+
+func TestCountCompletedJobsNamespaces(t *testing.T) {
+	e := setupTest(t, 1, map[string]string{"RESQUE_NAMESPACES": "app1,app2"})
+	for key, value := range map[string]string{
+		"app1:stat:processed": "10",
+		"app1:stat:failed":    "1",
+		"app2:stat:processed": "20",
+	} {
+		if err := e.redis.Set(key, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	completed, err := countCompletedJobs(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if completed != 31 {
+		t.Errorf("countCompletedJobs() = %d, want the 31 completed across namespaces", completed)
+	}
+}
+
+func TestStatsDocumentFetchedOncePerMeasurement(t *testing.T) {
+	var mu sync.Mutex
+	fetches := 0
+	stats := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		fetches++
+		n := fetches
+		mu.Unlock()
+		// each fetch is a different snapshot
+		fmt.Fprintf(w, `{"pending": %d, "working": %d, "processed": %d, "failed": 0}`, 10*n, n, 100*n)
+	}))
+	t.Cleanup(stats.Close)
+	setupTest(t, 1, map[string]string{
+		"STATS_SOURCE":          "http",
+		"STATS_URL":             stats.URL,
+		"SCALING_STRATEGY":      "arrival-rate",
+		"AVG_JOB_DURATION":      "1m",
+		"SCALE_DOWN_MAX_ACTIVE": "5",
+	})
+
+	if _, err := pollLoad(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if fetches != 1 {
+		t.Errorf("stats document fetched %d times for one measurement, want 1", fetches)
+	}
+	if autoscaler.cachedActive != 1 || autoscaler.cachedPending != 10 {
+		t.Errorf("active, pending = %d, %d, want 1 and 10 from the one snapshot", autoscaler.cachedActive, autoscaler.cachedPending)
+	}
+}
+
+func TestEvaluateWithTimeoutKeepsFastResults(t *testing.T) {
+	e := setupTest(t, 2, map[string]string{"EVALUATION_TIMEOUT": "5s"})
+	e.setQueues(t, map[string]int{"default": 3})
+	start := time.Now()
+	for i := 0; i < 200; i++ {
+		if _, _, ok := evaluateWithTimeout(autoscaler.scaleChan, false); !ok {
+			t.Fatalf("evaluation %d was abandoned, want its result returned", i)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("200 evaluations took %v, want them returned as they finish", elapsed)
+	}
+}
+
+func TestAllowedInstanceCountsOutsideEvaluation(t *testing.T) {
+	env := map[string]string{
+		"MIN_INSTANCES":            "3",
+		"MAX_INSTANCES":            "16",
+		"ALLOWED_INSTANCE_COUNTS":  "2,4,8,16",
+		"MAX_CONSECUTIVE_FAILURES": "1",
+		"FAILSAFE_INSTANCES":       "5",
+	}
+
+	e := setupTest(t, 10, env)
+	scaleToMinOnStartup()
+	if got := e.render.Scales(); len(got) != 1 || got[0] != 4 {
+		t.Errorf("startup scaled to %v, want the 4 allowed at the minimum of 3", got)
+	}
+	e.startScaleLoop(t)
+
+	n := 5
+	if d, ok := triggerDecision(triggerMessage{Instances: &n}); !ok || d.To != 8 {
+		t.Errorf("trigger for 5 instances scaled to %d (ok %v), want the allowed 8", d.To, ok)
+	}
+
+	rec := httptest.NewRecorder()
+	handleScale(rec, httptest.NewRequest("POST", "/scale?instances=9", nil))
+	if autoscaler.instances != 16 {
+		t.Errorf("POST /scale?instances=9 scaled to %d instances, want the allowed 16", autoscaler.instances)
+	}
+
+	e.redis.Close()
+	if d, _ := evaluate(context.Background(), false); d.To != 8 || d.Reason != "failsafe" {
+		t.Errorf("failsafe of 5 instances desired %d (%s), want the allowed 8", d.To, d.Reason)
+	}
+}
+
+func TestQueueLatencyLabelledByNamespace(t *testing.T) {
+	e := setupTest(t, 1, map[string]string{
+		"RESQUE_NAMESPACES": "app1,app2",
+		"ENQUEUED_AT_PATH":  "enqueued_at",
+	})
+	for namespace, waited := range map[string]time.Duration{"app1": time.Minute, "app2": time.Hour} {
+		if _, err := e.redis.SetAdd(namespace+":queues", "default"); err != nil {
+			t.Fatal(err)
+		}
+		job := fmt.Sprintf(`{"enqueued_at":%d}`, time.Now().Add(-waited).Unix())
+		if _, err := e.redis.Push(namespace+":queue:default", job); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := queueDepths(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	for namespace, want := range map[string]time.Duration{"app1": time.Minute, "app2": time.Hour} {
+		got := testutil.ToFloat64(queueLatency.WithLabelValues(namespace, "default"))
+		if math.Abs(got-want.Seconds()) > 5 {
+			t.Errorf("queue latency of %s:default = %vs, want about %vs", namespace, got, want.Seconds())
+		}
+	}
+}
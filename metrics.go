@@ -0,0 +1,166 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const metricsNamespace = "resque_autoscaler"
+
+var (
+	breakerStateGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "render_circuit_breaker_state",
+		Help:      "State of the Render API circuit breaker (0 = closed, 1 = open, 2 = half-open).",
+	})
+	iterationDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "iteration_duration_seconds",
+		Help:      "Duration of each evaluation loop iteration, excluding the sleep between iterations.",
+	})
+	iterationPhaseDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "iteration_phase_duration_seconds",
+		Help:      "Duration of each phase of an evaluation loop iteration: measuring load and deciding (count), and handing the decision to the scale loop (handoff), which waits for any previous scale to finish. The Render API time of the scales themselves is render_phase_seconds.",
+	}, []string{"phase"})
+	shadowDesiredInstances = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "shadow_desired_instances",
+		Help:      "Desired instance count under each shadow evaluation, before scale delays are applied.",
+	}, []string{"evaluation"})
+	clampedMin = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "clamped_min_total",
+		Help:      "Number of evaluations where the desired instance count was raised to the minimum.",
+	})
+	clampedMax = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "clamped_max_total",
+		Help:      "Number of evaluations where the desired instance count was lowered to the maximum.",
+	})
+	instanceSeconds = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "instance_seconds_total",
+		Help:      "Instance count integrated over time since the autoscaler started.",
+	})
+	estimatedCost = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "estimated_cost",
+		Help:      "Estimated cost of the instances run since the autoscaler started, based on INSTANCE_HOURLY_COST.",
+	})
+	queueLatency = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "queue_latency_seconds",
+		Help:      "How long the job at the head of each queue has been waiting.",
+	}, []string{"namespace", "queue"})
+	workerChurnRate = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "worker_churn_per_minute",
+		Help:      "Rate at which workers disappear within WORKER_CHURN_WINDOW of registering.",
+	})
+	leaderGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "leader",
+		Help:      "Whether this replica holds the leader lock (1) or is standing by (0).",
+	})
+	policyDesiredInstances = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "policy_desired_instances",
+		Help:      "Desired instance count under each policy combined by DRAIN_TIME_COMBINE, before clamping and scale delays.",
+	}, []string{"policy"})
+	backlogJobs = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "backlog_jobs",
+		Help:      "Measured load, in jobs.",
+	})
+	adjustedBacklogJobs = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "adjusted_backlog_jobs",
+		Help:      "Measured load less BACKLOG_BASELINE, which is what the pool is sized for.",
+	})
+	historyFloorInstances = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "history_floor_instances",
+		Help:      "Minimum instance count derived from the HISTORY_PERCENTILE of load over HISTORY_WINDOW.",
+	})
+	desiredInstancesGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "desired_instances",
+		Help:      "Instance count decided on by the latest evaluation.",
+	})
+	observedInstances = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "observed_instances",
+		Help:      "Instance count reported by Render. Only exported in dry-run mode.",
+	})
+	scheduledJobs = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "scheduled_jobs",
+		Help:      "Number of resque-scheduler jobs due within SCHEDULED_LOOKAHEAD.",
+	})
+	queueRequiredInstances = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "queue_required_instances",
+		Help:      "Instances each queue needs to meet its target wait under the queue-sla strategy.",
+	}, []string{"queue"})
+	gateBlocked = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "gate_blocked_total",
+		Help:      "Number of evaluations where each gate held the instance count at its current value.",
+	}, []string{"gate"})
+	gateAllowed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "gate_allowed_total",
+		Help:      "Number of evaluations where no gate held back a change, by direction (up or down).",
+	}, []string{"direction"})
+	errorsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "errors_total",
+		Help:      "Number of errors by kind: redis, render, stats (the STATS_URL endpoint), parse or scale.",
+	}, []string{"kind"})
+	aggregationJobs = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "aggregation_jobs",
+		Help:      "Job count under each of COMBINE_AGGREGATIONS.",
+	}, []string{"aggregation"})
+	evaluationTimeouts = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "evaluation_timeouts_total",
+		Help:      "Number of evaluation loop iterations abandoned after EVALUATION_TIMEOUT.",
+	})
+	workersPerInstanceGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "workers_per_instance",
+		Help:      "Workers each instance is assumed to run under LIVE_WORKER_CAPACITY.",
+	})
+	stuckWorkers = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "stuck_workers",
+		Help:      "Number of workers processing a job for longer than STUCK_JOB_THRESHOLD.",
+	})
+	instanceDrift = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "instance_drift",
+		Help:      "Instance count reported by Render less the tracked count, as of the latest DRIFT_CHECK_INTERVAL check.",
+	})
+	utilization = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "utilization",
+		Help:      "Pending jobs per worker slot, measured by the utilization strategy.",
+	})
+	redisPhaseDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "redis_phase_seconds",
+		Help:      "Time each measurement spends counting jobs in redis, for the strategies that count them.",
+	})
+	renderPhaseDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "render_phase_seconds",
+		Help:      "Time each scale spends in the Render API scale call, including calls rejected by the circuit breaker.",
+	})
+	renderAPIDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "render_api_request_duration_seconds",
+		Help:      "Duration of Render API requests.",
+	})
+)
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// observeOnly reports whether now falls within ObserveOnlyDuration of
+// startup, during which decisions are logged but not acted on. It logs when
+// the window ends and must be called with the state mutex held.
+func observeOnly(now time.Time) bool {
+	observing := now.Before(autoscaler.startTime.Add(autoscaler.config().ObserveOnlyDuration))
+	if !observing && autoscaler.observing {
+		log.Info("observe-only period over, acting on scaling decisions")
+	}
+	autoscaler.observing = observing
+	return observing
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+func TestObserveOnlyLogsWindowOnce(t *testing.T) {
+	e := setupTest(t, 1, map[string]string{
+		"MIN_INSTANCES":         "1",
+		"SCALE_UP_DELAY":        "0s",
+		"OBSERVE_ONLY_DURATION": "10m",
+		"LOG_LEVEL":             "info",
+	})
+	hook := test.NewGlobal()
+	t.Cleanup(func() { log.StandardLogger().ReplaceHooks(make(log.LevelHooks)) })
+	e.setQueues(t, map[string]int{"default": 50})
+
+	for i := 0; i < 5; i++ {
+		e.clock.Advance(time.Minute)
+		if _, applied := evaluate(context.Background(), true); applied {
+			t.Fatal("evaluation within the observe-only window applied its decision")
+		}
+	}
+	e.clock.Advance(10 * time.Minute)
+	if _, applied := evaluate(context.Background(), true); !applied {
+		t.Error("evaluation after the observe-only window didn't apply its decision")
+	}
+
+	var observeLogs []string
+	for _, entry := range hook.AllEntries() {
+		if entry.Level <= log.InfoLevel && strings.Contains(entry.Message, "observ") {
+			observeLogs = append(observeLogs, entry.Message)
+		}
+	}
+	// entering the window was logged at startup
+	if len(observeLogs) != 1 || !strings.Contains(observeLogs[0], "over") {
+		t.Errorf("logged %q at info level, want only the window ending", observeLogs)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/go-redis/redis/v8"
+	log "github.com/sirupsen/logrus"
+)
+
+// unpausedQueues splits a namespace's queues into those that are paused and
+// those that aren't. A queue is paused while the key PausedQueueKey names for
+// it, in the namespace, exists, which is how plugins like resque-pause mark
+// them. If the keys can't be read, every queue is treated as unpaused, so
+// that held work can't hide real load.
+func unpausedQueues(ctx context.Context, namespace string, queues []string) (unpaused, paused []string) {
+	pattern := autoscaler.config().PausedQueueKey
+	if pattern == "" || len(queues) == 0 {
+		return queues, nil
+	}
+	pipe := autoscaler.redis.Pipeline()
+	exists := make([]*redis.IntCmd, len(queues))
+	for i, queue := range queues {
+		key := namespacedKey(namespace, strings.ReplaceAll(pattern, "{queue}", queue))
+		exists[i] = pipe.Exists(ctx, key)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		recordError("redis", "failed to check for paused queues: %v", err)
+		return queues, nil
+	}
+
+	for i, queue := range queues {
+		if exists[i].Val() > 0 {
+			paused = append(paused, queue)
+		} else {
+			unpaused = append(unpaused, queue)
+		}
+	}
+	return unpaused, paused
+}
+
+// logPausedQueues logs changes to the set of paused queues.
+func logPausedQueues(paused []string) {
+	sort.Strings(paused)
+	list := strings.Join(paused, ", ")
+	autoscaler.mu.Lock()
+	defer autoscaler.mu.Unlock()
+	if list == autoscaler.pausedQueues {
+		return
+	}
+	if list == "" {
+		log.Info("no queues are paused")
+	} else {
+		log.Infof("excluding paused queues from the load: %s", list)
+	}
+	autoscaler.pausedQueues = list
+}
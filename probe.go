@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// evaluationState is the part of the state that an evaluation changes while
+// reaching its decision, including the load measurement it caches.
+type evaluationState struct {
+	samples       *ringBuffer
+	outputs       *ringBuffer
+	shadowSamples []*ringBuffer
+
+	loadFailures        int
+	consecutiveScaleUps int
+	scaleUpFrozen       bool
+	override            bool
+	idle                bool
+	unhealthy           bool
+	churning            bool
+	quietLogged         bool
+
+	lastAverage  float64
+	lastComputed int
+	lastGate     string
+	lastDesired  int
+
+	activeJobs    int
+	pendingJobs   int64
+	activeHistory []activeObservation
+
+	cachedLoad      int
+	cachedLoadTime  time.Time
+	cachedActive    int
+	cachedPending   int64
+	nonEmptySamples map[string]int
+	transientJobs   int64
+	maxQueueLatency time.Duration
+	lastArrivals    *arrivalObservation
+}
+
+// saveEvaluationState copies the evaluation state. It must be called with the
+// state mutex held.
+func saveEvaluationState() evaluationState {
+	s := evaluationState{
+		samples:             autoscaler.samples.Clone(),
+		outputs:             autoscaler.outputs.Clone(),
+		loadFailures:        autoscaler.loadFailures,
+		consecutiveScaleUps: autoscaler.consecutiveScaleUps,
+		scaleUpFrozen:       autoscaler.scaleUpFrozen,
+		override:            autoscaler.override,
+		idle:                autoscaler.idle,
+		unhealthy:           autoscaler.unhealthy,
+		churning:            autoscaler.churning,
+		quietLogged:         autoscaler.quietLogged,
+		lastAverage:         autoscaler.lastAverage,
+		lastComputed:        autoscaler.lastComputed,
+		lastGate:            autoscaler.lastGate,
+		lastDesired:         autoscaler.lastDesired,
+		activeJobs:          autoscaler.activeJobs,
+		pendingJobs:         autoscaler.pendingJobs,
+		activeHistory:       append([]activeObservation(nil), autoscaler.activeHistory...),
+		cachedLoad:          autoscaler.cachedLoad,
+		cachedLoadTime:      autoscaler.cachedLoadTime,
+		cachedActive:        autoscaler.cachedActive,
+		cachedPending:       autoscaler.cachedPending,
+		transientJobs:       autoscaler.transientJobs,
+		maxQueueLatency:     autoscaler.maxQueueLatency,
+		lastArrivals:        autoscaler.lastArrivals,
+	}
+	for _, shadow := range autoscaler.shadows {
+		s.shadowSamples = append(s.shadowSamples, shadow.samples.Clone())
+	}
+	if autoscaler.nonEmptySamples != nil {
+		s.nonEmptySamples = make(map[string]int, len(autoscaler.nonEmptySamples))
+		for queue, n := range autoscaler.nonEmptySamples {
+			s.nonEmptySamples[queue] = n
+		}
+	}
+	return s
+}
+
+// restore puts back a saved evaluation state. It must be called with the
+// state mutex held.
+func (s evaluationState) restore() {
+	autoscaler.samples = s.samples
+	autoscaler.outputs = s.outputs
+	for i, shadow := range autoscaler.shadows {
+		shadow.samples = s.shadowSamples[i]
+	}
+	autoscaler.loadFailures = s.loadFailures
+	autoscaler.consecutiveScaleUps = s.consecutiveScaleUps
+	autoscaler.scaleUpFrozen = s.scaleUpFrozen
+	autoscaler.override = s.override
+	autoscaler.idle = s.idle
+	autoscaler.unhealthy = s.unhealthy
+	autoscaler.churning = s.churning
+	autoscaler.quietLogged = s.quietLogged
+	autoscaler.lastAverage = s.lastAverage
+	autoscaler.lastComputed = s.lastComputed
+	autoscaler.lastGate = s.lastGate
+	autoscaler.lastDesired = s.lastDesired
+	autoscaler.activeJobs = s.activeJobs
+	autoscaler.pendingJobs = s.pendingJobs
+	autoscaler.activeHistory = s.activeHistory
+	autoscaler.cachedLoad = s.cachedLoad
+	autoscaler.cachedLoadTime = s.cachedLoadTime
+	autoscaler.cachedActive = s.cachedActive
+	autoscaler.cachedPending = s.cachedPending
+	autoscaler.nonEmptySamples = s.nonEmptySamples
+	autoscaler.transientJobs = s.transientJobs
+	autoscaler.maxQueueLatency = s.maxQueueLatency
+	autoscaler.lastArrivals = s.lastArrivals
+}
+
+// probe returns the decision an evaluation would reach now without acting on
+// it. The evaluation state is restored afterwards, and the probe neither
+// alerts nor shows up in the decision buffer, so probing doesn't change the
+// decisions of later evaluations.
+func probe(ctx context.Context) scaleDecision {
+	autoscaler.evaluation.Lock()
+	defer autoscaler.evaluation.Unlock()
+	autoscaler.mu.Lock()
+	saved := saveEvaluationState()
+	autoscaler.probing = true
+	autoscaler.mu.Unlock()
+	defer func() {
+		autoscaler.mu.Lock()
+		defer autoscaler.mu.Unlock()
+		saved.restore()
+		autoscaler.probing = false
+	}()
+	d, _ := runEvaluation(ctx, false)
+	return d
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestProbeLeavesStateAlone(t *testing.T) {
+	e := setupTest(t, 1, map[string]string{
+		"NUM_SAMPLES":          "3",
+		"DECISION_BUFFER_SIZE": "10",
+	})
+	e.setQueues(t, map[string]int{"default": 10})
+
+	for i := 0; i < 5; i++ {
+		probe(context.Background())
+	}
+	autoscaler.mu.Lock()
+	samples, decisions := autoscaler.samples.Len(), len(autoscaler.decisions)
+	autoscaler.mu.Unlock()
+	if samples != 0 || decisions != 0 {
+		t.Errorf("after probing: %d samples and %d decisions, want none", samples, decisions)
+	}
+
+	evaluate(context.Background(), false)
+	autoscaler.mu.Lock()
+	samples, decisions = autoscaler.samples.Len(), len(autoscaler.decisions)
+	autoscaler.mu.Unlock()
+	if samples != 1 || decisions != 1 {
+		t.Errorf("after evaluating: %d samples and %d decisions, want 1 of each", samples, decisions)
+	}
+}
+
+func TestProbeDoesNotCountLoadFailures(t *testing.T) {
+	e := setupTest(t, 1, map[string]string{"MAX_CONSECUTIVE_FAILURES": "2"})
+	e.redis.Close()
+
+	for i := 0; i < 3; i++ {
+		if d := probe(context.Background()); d.Reason != "failsafe" {
+			t.Fatalf("probe reason = %q, want failsafe", d.Reason)
+		}
+	}
+	autoscaler.mu.Lock()
+	failures := autoscaler.loadFailures
+	autoscaler.mu.Unlock()
+	if failures != 0 {
+		t.Errorf("loadFailures = %d after probing, want 0", failures)
+	}
+}
+
+func TestProbeDoesNotAlertOnChurn(t *testing.T) {
+	var alerts int32
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&alerts, 1)
+	}))
+	t.Cleanup(webhook.Close)
+	e := setupTest(t, 1, map[string]string{
+		"MIN_INSTANCES":          "1",
+		"WORKER_CHURN_THRESHOLD": "1",
+		"ALERT_WEBHOOK_URL":      webhook.URL,
+	})
+	e.setQueues(t, map[string]int{"default": 100})
+	churn := func() {
+		autoscaler.mu.Lock()
+		defer autoscaler.mu.Unlock()
+		now := time.Now()
+		autoscaler.workersSeen = map[string]time.Time{}
+		autoscaler.departures = nil
+		for i := 0; i < 50; i++ {
+			autoscaler.departures = append(autoscaler.departures, now)
+		}
+	}
+
+	churn()
+	e.clock.Advance(time.Hour)
+	probe(context.Background())
+	autoscaler.mu.Lock()
+	churning := autoscaler.churning
+	autoscaler.mu.Unlock()
+	if churning {
+		t.Error("churning = true after probing, want the probe to leave it alone")
+	}
+	time.Sleep(100 * time.Millisecond)
+	if n := atomic.LoadInt32(&alerts); n != 0 {
+		t.Errorf("probing sent %d alerts, want none", n)
+	}
+
+	churn()
+	evaluate(context.Background(), false)
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt32(&alerts) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("evaluating never alerted on the churn")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
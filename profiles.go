@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// scalingProfile is a preset for the parameters that are easiest to get
+// wrong. Values given explicitly in the environment take precedence.
+type scalingProfile struct {
+	scaleUpDelay   time.Duration
+	scaleDownDelay time.Duration
+	numSamples     int
+}
+
+var scalingProfiles = map[string]scalingProfile{
+	"responsive": {scaleUpDelay: 15 * time.Second, scaleDownDelay: 15 * time.Minute, numSamples: 3},
+	"balanced":   {scaleUpDelay: time.Minute, scaleDownDelay: 10 * time.Minute, numSamples: 5},
+	"cost-saver": {scaleUpDelay: 3 * time.Minute, scaleDownDelay: 2 * time.Minute, numSamples: 10},
+}
+
+func applyScalingProfile(config *AutoscalerConfig) error {
+	if config.ScalingProfile == "" {
+		return nil
+	}
+	profile, ok := scalingProfiles[config.ScalingProfile]
+	if !ok {
+		return fmt.Errorf("unknown scaling profile %q", config.ScalingProfile)
+	}
+	if _, set := os.LookupEnv("SCALE_UP_DELAY"); !set {
+		config.ScaleUpDelay = profile.scaleUpDelay
+	}
+	if _, set := os.LookupEnv("SCALE_DOWN_DELAY"); !set {
+		config.ScaleDownDelay = profile.scaleDownDelay
+	}
+	if _, set := os.LookupEnv("NUM_SAMPLES"); !set {
+		config.NumSamples = profile.numSamples
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// queueGroup is a set of queues, matched by glob patterns, whose combined
+// backlog is sized as a single pool.
+type queueGroup struct {
+	name     string
+	patterns []string
+	ratio    float64
+}
+
+// parseQueueGroups builds groups from a map of group name to "|"-separated
+// queue patterns. Groups without a ratio use defaultRatio.
+func parseQueueGroups(groups map[string]string, ratios map[string]float64, defaultRatio float64) ([]queueGroup, error) {
+	names := make([]string, 0, len(groups))
+	for name := range groups {
+		names = append(names, name)
+	}
+	// sort so that a queue matching several groups always lands in the same one
+	sort.Strings(names)
+
+	var parsed []queueGroup
+	for _, name := range names {
+		patterns := strings.Split(groups[name], "|")
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("invalid pattern %q for queue group %s", pattern, name)
+			}
+		}
+		ratio, ok := ratios[name]
+		if !ok {
+			ratio = defaultRatio
+		}
+		if ratio <= 0 {
+			return nil, fmt.Errorf("invalid ratio %v for queue group %s", ratio, name)
+		}
+		parsed = append(parsed, queueGroup{name: name, patterns: patterns, ratio: ratio})
+	}
+	for name := range ratios {
+		if _, ok := groups[name]; !ok {
+			return nil, fmt.Errorf("ratio given for unknown queue group %s", name)
+		}
+	}
+	return parsed, nil
+}
+
+// queueGroupFor returns the index of the first group matching queue, or -1.
+func queueGroupFor(queue string) int {
+	for i, group := range autoscaler.queueGroups {
+		for _, pattern := range group.patterns {
+			if ok, _ := path.Match(pattern, queue); ok {
+				return i
+			}
+		}
+	}
+	return -1
+}
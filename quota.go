@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	log "github.com/sirupsen/logrus"
+)
+
+// refreshQuota re-reads the instance quota from QuotaKey if the cached value
+// is older than QuotaRefreshInterval. A missing key lifts the quota. It must
+// not be called with the state mutex held.
+func refreshQuota(ctx context.Context) {
+	if autoscaler.config().QuotaKey == "" || autoscaler.redis == nil {
+		return
+	}
+	autoscaler.mu.Lock()
+	fresh := time.Since(autoscaler.quotaCheckTime) < autoscaler.config().QuotaRefreshInterval
+	autoscaler.mu.Unlock()
+	if fresh {
+		return
+	}
+
+	quota := -1
+	val, err := autoscaler.redis.Get(ctx, autoscaler.config().QuotaKey).Int()
+	if err == nil {
+		quota = val
+	} else if err != redis.Nil {
+		recordError("redis", "failed to read instance quota from redis: %v", err)
+		return
+	}
+
+	autoscaler.mu.Lock()
+	defer autoscaler.mu.Unlock()
+	autoscaler.quota = quota
+	autoscaler.quotaCheckTime = time.Now()
+}
+
+// maxInstances returns the maximum instance count in effect: the configured
+// maximum, lowered to the quota if one is set. It must be called with the
+// state mutex held.
+func maxInstances() int {
+	max := autoscaler.config().MaxInstances
+	binding := autoscaler.quota >= 0 && autoscaler.quota < max
+	if binding && !autoscaler.quotaBinding {
+		log.Infof("instance quota of %d is below the configured maximum of %d, limiting to the quota",
+			autoscaler.quota, max)
+	} else if !binding && autoscaler.quotaBinding {
+		log.Infof("instance quota no longer limits the configured maximum of %d", max)
+	}
+	autoscaler.quotaBinding = binding
+	if binding {
+		return autoscaler.quota
+	}
+	return max
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// reconcileLoop periodically compares the tracked instance count with the
+// count Render reports. If they differ while the autoscaler is content with
+// the tracked count, it reissues a scale to the tracked count, since no
+// evaluation would otherwise notice the drift.
+func reconcileLoop(c chan scaleDecision) {
+	interval := autoscaler.config().ReconcileInterval
+	for {
+		time.Sleep(interval)
+		if !isLeader() {
+			continue
+		}
+		actual, err := fetchInstanceCount(autoscaler.ctx)
+		if err != nil {
+			recordError("render", "failed to retrieve instance count for reconciliation: %v", err)
+			continue
+		}
+
+		autoscaler.mu.Lock()
+		tracked := autoscaler.instances
+		settled := autoscaler.lastDesired == tracked && !observeOnly(autoscaler.clock.Now()) &&
+			!autoscaler.shuttingDown
+		// a recent scale may not be reflected by the render api yet
+		recent := autoscaler.clock.Now().Sub(autoscaler.lastScaleTime) < interval
+		autoscaler.mu.Unlock()
+
+		if actual == tracked || !settled || recent {
+			continue
+		}
+		log.Warnf("render reports %d instances but %d are tracked, scaling to correct the drift", actual, tracked)
+		sendDecision(c, scaleDecision{From: actual, To: tracked, Reason: "reconcile"})
+	}
+}
+
+// observeLoop periodically exports the instance count Render reports. It runs
+// in dry-run mode, where the tracked count is what the autoscaler would have
+// scaled to, so the two can be compared side by side.
+func observeLoop() {
+	for {
+		actual, err := fetchInstanceCount(autoscaler.ctx)
+		if err != nil {
+			recordError("render", "failed to retrieve instance count: %v", err)
+		} else {
+			observedInstances.Set(float64(actual))
+		}
+		time.Sleep(autoscaler.config().ReconcileInterval)
+	}
+}
+
+// driftLoop periodically compares the tracked instance count with the count
+// Render reports and exports the difference, without acting on it. Drift
+// right after a scale is expected while Render catches up, so it is only
+// logged once the last scale is an interval old.
+func driftLoop(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		actual, err := fetchInstanceCount(autoscaler.ctx)
+		if err != nil {
+			recordError("render", "failed to retrieve instance count for drift check: %v", err)
+			continue
+		}
+
+		autoscaler.mu.Lock()
+		tracked := autoscaler.instances
+		recent := autoscaler.clock.Now().Sub(autoscaler.lastScaleTime) < interval
+		autoscaler.mu.Unlock()
+
+		instanceDrift.Set(float64(actual - tracked))
+		if actual != tracked && !recent {
+			log.WithFields(log.Fields{"tracked": tracked, "actual": actual, "drift": actual - tracked}).
+				Warnf("render reports %d instances but %d are tracked", actual, tracked)
+		}
+	}
+}
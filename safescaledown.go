@@ -0,0 +1,48 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// activeObservation is an active job count and when it was measured.
+type activeObservation struct {
+	jobs int
+	at   time.Time
+}
+
+// recordActiveJobs remembers an active job count, or that it couldn't be
+// measured if jobs is negative, and forgets counts older than
+// SafeScaleDownWindow. It must be called with the state mutex held.
+func recordActiveJobs(jobs int) {
+	now := autoscaler.clock.Now()
+	window := autoscaler.config().SafeScaleDownWindow
+	history := append(autoscaler.activeHistory, activeObservation{jobs: jobs, at: now})
+	i := 0
+	for i < len(history) && now.Sub(history[i].at) >= window {
+		i++
+	}
+	autoscaler.activeHistory = history[i:]
+}
+
+// safeScaleDownFloor returns the instance count needed for the peak active
+// job count seen within SafeScaleDownWindow. If any count in the window
+// couldn't be measured, the floor is the current instance count. It must be
+// called with the state mutex held.
+func safeScaleDownFloor() int {
+	peak := 0
+	for _, obs := range autoscaler.activeHistory {
+		if obs.jobs < 0 {
+			return autoscaler.instances
+		}
+		if obs.jobs > peak {
+			peak = obs.jobs
+		}
+	}
+	floor := int(math.Ceil(float64(peak) / workersPerInstance()))
+	if floor > autoscaler.instances {
+		// the floor only holds back scale-downs, it never scales up
+		return autoscaler.instances
+	}
+	return floor
+}
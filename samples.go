@@ -0,0 +1,146 @@
+package main
+
+import (
+	"math"
+	"sort"
+)
+
+// ringBuffer is a fixed-size window of the most recent samples. Pushing onto
+// a full buffer overwrites the oldest sample.
+type ringBuffer struct {
+	values []int
+	start  int
+	count  int
+}
+
+func newRingBuffer(size int) *ringBuffer {
+	if size < 1 {
+		size = 1
+	}
+	return &ringBuffer{values: make([]int, size)}
+}
+
+func (r *ringBuffer) Push(x int) {
+	end := (r.start + r.count) % len(r.values)
+	r.values[end] = x
+	if r.count < len(r.values) {
+		r.count++
+	} else {
+		r.start = (r.start + 1) % len(r.values)
+	}
+}
+
+// Clone returns a copy of the buffer that shares no storage with it.
+func (r *ringBuffer) Clone() *ringBuffer {
+	values := make([]int, len(r.values))
+	copy(values, r.values)
+	return &ringBuffer{values: values, start: r.start, count: r.count}
+}
+
+func (r *ringBuffer) Len() int {
+	return r.count
+}
+
+func (r *ringBuffer) Full() bool {
+	return r.count == len(r.values)
+}
+
+// At returns the i-th sample, where 0 is the oldest.
+func (r *ringBuffer) At(i int) int {
+	return r.values[(r.start+i)%len(r.values)]
+}
+
+func (r *ringBuffer) Average() float64 {
+	if r.count == 0 {
+		return 0
+	}
+	var sum int64
+	for i := 0; i < r.count; i++ {
+		sum += int64(r.At(i))
+	}
+	return float64(sum) / float64(r.count)
+}
+
+// WeightedMean returns the mean of the samples weighted linearly by recency:
+// the oldest sample has weight 1 and the newest has weight Len().
+func (r *ringBuffer) WeightedMean() float64 {
+	if r.count == 0 {
+		return 0
+	}
+	var sum, weights int64
+	for i := 0; i < r.count; i++ {
+		sum += int64(i+1) * int64(r.At(i))
+		weights += int64(i + 1)
+	}
+	return float64(sum) / float64(weights)
+}
+
+// Percentile returns the p-th percentile (0-100) of the samples using the
+// nearest-rank method.
+func (r *ringBuffer) Percentile(p float64) float64 {
+	if r.count == 0 {
+		return 0
+	}
+	sorted := make([]int, r.count)
+	for i := range sorted {
+		sorted[i] = r.At(i)
+	}
+	sort.Ints(sorted)
+	return float64(nearestRank(sorted, p))
+}
+
+// nearestRank returns the pth percentile of a sorted, non-empty slice using
+// the nearest-rank method.
+func nearestRank(sorted []int, p float64) int {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	if rank > len(sorted) {
+		rank = len(sorted)
+	}
+	return sorted[rank-1]
+}
+
+// Slope returns the least-squares slope, in jobs per sample, of the most
+// recent k samples. It returns 0 when fewer than two samples are available.
+func (r *ringBuffer) Slope(k int) float64 {
+	slope, _ := r.linearFit(k)
+	return slope
+}
+
+// Predict extrapolates a least-squares line through all samples to estimate
+// the value steps samples after the newest one. The result is never negative.
+func (r *ringBuffer) Predict(steps float64) float64 {
+	slope, intercept := r.linearFit(r.count)
+	predicted := intercept + slope*(float64(r.count-1)+steps)
+	if predicted < 0 {
+		return 0
+	}
+	return predicted
+}
+
+// linearFit fits a least-squares line through the most recent k samples,
+// with x = 0 at the oldest sample in the window. With fewer than two samples
+// the slope is 0 and the intercept is the average.
+func (r *ringBuffer) linearFit(k int) (slope, intercept float64) {
+	if k > r.count {
+		k = r.count
+	}
+	if k < 2 {
+		return 0, r.Average()
+	}
+	offset := r.count - k
+	var sumX, sumY, sumXY, sumXX float64
+	for i := 0; i < k; i++ {
+		x, y := float64(offset+i), float64(r.At(offset+i))
+		sumX += x
+		sumY += y
+		sumXY += x * y
+		sumXX += x * x
+	}
+	n := float64(k)
+	slope = (n*sumXY - sumX*sumY) / (n*sumXX - sumX*sumX)
+	intercept = (sumY - slope*sumX) / n
+	return slope, intercept
+}
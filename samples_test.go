@@ -0,0 +1,129 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func samplesOf(r *ringBuffer) []int {
+	values := make([]int, r.Len())
+	for i := range values {
+		values[i] = r.At(i)
+	}
+	return values
+}
+
+func TestRingBufferWraparound(t *testing.T) {
+	r := newRingBuffer(3)
+	for i, want := range [][]int{
+		{1},
+		{1, 2},
+		{1, 2, 3},
+		{2, 3, 4},
+		{3, 4, 5},
+		{4, 5, 6},
+		{5, 6, 7},
+	} {
+		r.Push(i + 1)
+		if got := samplesOf(r); !reflect.DeepEqual(got, want) {
+			t.Errorf("after pushing %d: samples = %v, want %v", i+1, got, want)
+		}
+		if full := r.Len() == 3; r.Full() != full {
+			t.Errorf("after pushing %d: Full() = %v, want %v", i+1, r.Full(), full)
+		}
+	}
+	if got, want := r.Average(), 6.0; got != want {
+		t.Errorf("Average() = %v, want %v", got, want)
+	}
+}
+
+func TestRingBufferMinimumSize(t *testing.T) {
+	r := newRingBuffer(0)
+	r.Push(1)
+	r.Push(2)
+	if got, want := samplesOf(r), []int{2}; !reflect.DeepEqual(got, want) {
+		t.Errorf("samples = %v, want %v", got, want)
+	}
+	if !r.Full() {
+		t.Error("Full() = false after a push, want true")
+	}
+}
+
+func TestRingBufferEmpty(t *testing.T) {
+	r := newRingBuffer(3)
+	if r.Full() {
+		t.Error("Full() = true, want false")
+	}
+	if got := r.Average(); got != 0 {
+		t.Errorf("Average() = %v, want 0", got)
+	}
+}
+
+func TestRingBufferWeightedMean(t *testing.T) {
+	r := newRingBuffer(3)
+	for _, v := range []int{1, 2, 3} {
+		r.Push(v)
+	}
+	// Weights are 1, 2 and 3 from oldest to newest.
+	if got, want := r.WeightedMean(), (1*1+2*2+3*3)/6.0; got != want {
+		t.Errorf("WeightedMean() = %v, want %v", got, want)
+	}
+
+	// After wrapping around, the weights follow recency rather than the
+	// position in the underlying slice.
+	r.Push(10)
+	if got, want := r.WeightedMean(), (1*2+2*3+3*10)/6.0; got != want {
+		t.Errorf("WeightedMean() after wraparound = %v, want %v", got, want)
+	}
+	if r.WeightedMean() <= r.Average() {
+		t.Errorf("WeightedMean() = %v, want more than Average() = %v for a rising window", r.WeightedMean(), r.Average())
+	}
+}
+
+func TestRingBufferWeightedMeanEmpty(t *testing.T) {
+	if got := newRingBuffer(3).WeightedMean(); got != 0 {
+		t.Errorf("WeightedMean() = %v, want 0", got)
+	}
+}
+
+func TestRingBufferPredict(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		samples []int
+		steps   float64
+		want    float64
+	}{
+		{"increasing", []int{10, 20, 30, 40}, 1, 50},
+		{"increasing two steps ahead", []int{10, 20, 30, 40}, 2, 60},
+		{"decreasing", []int{40, 30, 20, 10}, 1, 0},
+		{"decreasing slowly", []int{40, 35, 30, 25}, 2, 15},
+		{"decreasing below zero", []int{30, 20, 10, 0}, 3, 0},
+		{"flat", []int{7, 7, 7}, 5, 7},
+		{"single sample", []int{12}, 1, 12},
+	} {
+		r := newRingBuffer(len(tt.samples))
+		for _, v := range tt.samples {
+			r.Push(v)
+		}
+		if got := r.Predict(tt.steps); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("%s: Predict(%v) = %v, want %v", tt.name, tt.steps, got, tt.want)
+		}
+	}
+}
+
+func TestRingBufferSlope(t *testing.T) {
+	r := newRingBuffer(5)
+	for _, v := range []int{50, 40, 10, 20, 30} {
+		r.Push(v)
+	}
+	if got := r.Slope(3); math.Abs(got-10) > 1e-9 {
+		t.Errorf("Slope(3) = %v, want 10 for the increasing tail", got)
+	}
+	if got := r.Slope(5); got >= 0 {
+		t.Errorf("Slope(5) = %v, want negative for the whole window", got)
+	}
+	if got := r.Slope(1); got != 0 {
+		t.Errorf("Slope(1) = %v, want 0", got)
+	}
+}
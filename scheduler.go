@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	log "github.com/sirupsen/logrus"
+)
+
+// upcomingScheduledJobs returns the number of jobs resque-scheduler will
+// enqueue within ScheduledLookahead, if that is at least ScheduledThreshold,
+// and zero otherwise, summed across namespaces. resque-scheduler keeps the timestamps of delayed jobs,
+// including recurring ones once they are queued for their next run, in a
+// sorted set and the jobs due at each timestamp in a list.
+func upcomingScheduledJobs(ctx context.Context) int64 {
+	lookahead := autoscaler.config().ScheduledLookahead
+	if lookahead <= 0 || autoscaler.redis == nil {
+		return 0
+	}
+	now := time.Now()
+	var jobs int64
+	for _, namespace := range resqueNamespaces() {
+		timestamps, err := autoscaler.redis.ZRangeByScore(ctx, namespacedKey(namespace, "delayed_queue_schedule"), &redis.ZRangeBy{
+			Min: strconv.FormatInt(now.Unix(), 10),
+			Max: strconv.FormatInt(now.Add(lookahead).Unix(), 10),
+		}).Result()
+		if err != nil {
+			recordError("redis", "failed to retrieve resque-scheduler schedule from redis: %v", err)
+			return 0
+		}
+		for _, ts := range timestamps {
+			n, err := autoscaler.redis.LLen(ctx, namespacedKey(namespace, "delayed:"+ts)).Result()
+			if err != nil {
+				recordError("redis", "failed to count delayed jobs due at %s: %v", ts, err)
+				continue
+			}
+			jobs += n
+		}
+	}
+	scheduledJobs.Set(float64(jobs))
+	if jobs < autoscaler.config().ScheduledThreshold {
+		return 0
+	}
+	log.Debugf("%d scheduled jobs due within %s, counting them as load", jobs, lookahead)
+	return jobs
+}
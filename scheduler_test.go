@@ -0,0 +1,31 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestUpcomingScheduledJobsNamespaces(t *testing.T) {
+	e := setupTest(t, 1, map[string]string{
+		"SCHEDULED_LOOKAHEAD": "10m",
+		"RESQUE_NAMESPACES":   "app1,app2",
+	})
+	due := time.Now().Add(5 * time.Minute).Unix()
+	ts := strconv.FormatInt(due, 10)
+	for namespace, jobs := range map[string]int{"app1": 2, "app2": 3} {
+		if _, err := e.redis.ZAdd(namespace+":delayed_queue_schedule", float64(due), ts); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < jobs; i++ {
+			if _, err := e.redis.Push(namespace+":delayed:"+ts, `{"class":"Job"}`); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	if got := upcomingScheduledJobs(context.Background()); got != 5 {
+		t.Errorf("upcomingScheduledJobs() = %d, want the 5 due across namespaces", got)
+	}
+}
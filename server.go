@@ -0,0 +1,192 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	log "github.com/sirupsen/logrus"
+)
+
+func startHTTPServer() {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/status", handleStatus)
+	mux.HandleFunc("/evaluate", adminOnly(handleEvaluate, http.MethodPost))
+	mux.HandleFunc("/override", adminOnly(handleOverride, http.MethodPost))
+	mux.HandleFunc("/bounds", adminOnly(handleBounds, http.MethodGet, http.MethodPost))
+	mux.HandleFunc("/scale", adminOnly(handleScale, http.MethodPost))
+	mux.HandleFunc("/decisions", adminOnly(handleDecisions, http.MethodGet))
+	mux.HandleFunc("/config", adminOnly(handleConfig, http.MethodGet))
+
+	go func() {
+		log.Infof("listening on %s", autoscaler.config().ListenAddress)
+		if err := http.ListenAndServe(autoscaler.config().ListenAddress, mux); err != nil {
+			log.Fatal(err)
+		}
+	}()
+}
+
+// adminOnly restricts h to the given methods and to requests carrying the
+// configured admin secret.
+func adminOnly(h http.HandlerFunc, methods ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		allowed := false
+		for _, method := range methods {
+			allowed = allowed || r.Method == method
+		}
+		if !allowed {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		secret := autoscaler.config().AdminSecret
+		given := r.Header.Get("X-Admin-Secret")
+		if secret == "" || subtle.ConstantTimeCompare([]byte(given), []byte(secret)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h(w, r)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Errorf("failed to write response: %v", err)
+	}
+}
+
+type statusResponse struct {
+	Instances               int            `json:"instances"`
+	LastError               string         `json:"lastError,omitempty"`
+	LastErrorTime           *time.Time     `json:"lastErrorTime,omitempty"`
+	Errors                  map[string]int `json:"errors,omitempty"`
+	LastSuccessfulScaleTime *time.Time     `json:"lastSuccessfulScaleTime,omitempty"`
+}
+
+func handleStatus(w http.ResponseWriter, r *http.Request) {
+	autoscaler.mu.Lock()
+	status := statusResponse{
+		Instances:               autoscaler.instances,
+		LastError:               autoscaler.lastError,
+		LastErrorTime:           timeOrNil(autoscaler.lastErrorTime),
+		Errors:                  make(map[string]int, len(autoscaler.errorCounts)),
+		LastSuccessfulScaleTime: timeOrNil(autoscaler.lastSuccessfulScaleTime),
+	}
+	for kind, n := range autoscaler.errorCounts {
+		status.Errors[kind] = n
+	}
+	autoscaler.mu.Unlock()
+	writeJSON(w, status)
+}
+
+func timeOrNil(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+type evaluateResponse struct {
+	CurrentInstances int  `json:"currentInstances"`
+	DesiredInstances int  `json:"desiredInstances"`
+	Applied          bool `json:"applied"`
+}
+
+func handleEvaluate(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("apply") != "true" || !isLeader() {
+		decision := probe(r.Context())
+		writeJSON(w, evaluateResponse{
+			CurrentInstances: decision.From,
+			DesiredInstances: decision.To,
+		})
+		return
+	}
+	decision, applied := evaluate(r.Context(), true)
+	if applied {
+		log.Info("applying out-of-band evaluation requested over http")
+		sendDecision(autoscaler.scaleChan, decision)
+	}
+	writeJSON(w, evaluateResponse{
+		CurrentInstances: decision.From,
+		DesiredInstances: decision.To,
+		Applied:          applied,
+	})
+}
+
+func handleOverride(w http.ResponseWriter, r *http.Request) {
+	n, err := strconv.Atoi(r.URL.Query().Get("instances"))
+	if err != nil || n < 0 {
+		http.Error(w, "instances must be a non-negative integer", http.StatusBadRequest)
+		return
+	}
+	ttl, err := time.ParseDuration(r.URL.Query().Get("ttl"))
+	if err != nil || ttl <= 0 {
+		http.Error(w, "ttl must be a positive duration", http.StatusBadRequest)
+		return
+	}
+	if err := setOverride(n, ttl); err != nil {
+		recordError("redis", "failed to set instance override: %v", err)
+		http.Error(w, "failed to set override", http.StatusInternalServerError)
+		return
+	}
+	log.Infof("instance override to %d for %s set over http", n, ttl)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleScale scales to an exact instance count, clamped to the bounds but
+// regardless of the scale delays.
+func handleScale(w http.ResponseWriter, r *http.Request) {
+	n, err := strconv.Atoi(r.URL.Query().Get("instances"))
+	if err != nil {
+		http.Error(w, "instances must be an integer", http.StatusBadRequest)
+		return
+	}
+	if !isLeader() {
+		http.Error(w, "not the leader", http.StatusServiceUnavailable)
+		return
+	}
+
+	autoscaler.mu.Lock()
+	now := autoscaler.clock.Now()
+	if autoscaler.shuttingDown {
+		autoscaler.mu.Unlock()
+		http.Error(w, "shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	if observeOnly(now) {
+		autoscaler.mu.Unlock()
+		http.Error(w, "observing only", http.StatusServiceUnavailable)
+		return
+	}
+	if max := maxInstances(); n > max {
+		n = max
+	}
+	if min := minInstances(now); n < min {
+		n = min
+	}
+	n = allowedInstanceCount(n, minInstances(now), maxInstances())
+	decision := scaleDecision{From: autoscaler.instances, To: n, Reason: "operator"}
+	applied := decision.To != decision.From
+	if applied {
+		autoscaler.instances = n
+		autoscaler.lastScaleTime = now
+		if decision.To > decision.From {
+			autoscaler.lastScaleUp = now
+		}
+	}
+	autoscaler.mu.Unlock()
+
+	if applied {
+		log.Infof("operator forced scale from %d to %d instances over http", decision.From, decision.To)
+		sendDecision(autoscaler.scaleChan, decision)
+	}
+	writeJSON(w, evaluateResponse{
+		CurrentInstances: decision.From,
+		DesiredInstances: decision.To,
+		Applied:          applied,
+	})
+}
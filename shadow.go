@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// shadowEvaluation computes what the desired instance count would be under
+// an alternative aggregation and sample window. It never drives scaling.
+type shadowEvaluation struct {
+	name        string
+	aggregation string
+	samples     *ringBuffer
+}
+
+// parseShadowEvaluations parses entries of the form "aggregation:numSamples",
+// e.g. "weighted-mean:10".
+func parseShadowEvaluations(specs []string) ([]*shadowEvaluation, error) {
+	var shadows []*shadowEvaluation
+	for _, spec := range specs {
+		parts := strings.SplitN(spec, ":", 2)
+		if len(parts) != 2 || !validAggregation(parts[0]) {
+			return nil, fmt.Errorf("invalid shadow evaluation %q", spec)
+		}
+		n, err := strconv.Atoi(parts[1])
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid shadow evaluation %q", spec)
+		}
+		shadows = append(shadows, &shadowEvaluation{
+			name:        spec,
+			aggregation: parts[0],
+			samples:     newRingBuffer(n),
+		})
+	}
+	return shadows, nil
+}
+
+// runShadowEvaluations must be called with the state mutex held.
+func runShadowEvaluations(jobs int) {
+	for _, shadow := range autoscaler.shadows {
+		shadow.samples.Push(jobs)
+		if !shadow.samples.Full() {
+			continue
+		}
+		avg := aggregate(shadow.samples, shadow.aggregation)
+		desired := autoscaler.config().BaseInstances + int(math.Ceil(avg/workersPerInstance()))
+		if max := maxInstances(); desired > max {
+			desired = max
+		}
+		if min := minInstances(autoscaler.clock.Now()); desired < min {
+			desired = min
+		}
+		shadowDesiredInstances.WithLabelValues(shadow.name).Set(float64(desired))
+		log.Debugf("shadow evaluation %s: %d instances", shadow.name, desired)
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// waitForShutdown blocks until the process is asked to terminate and then
+// shuts down.
+func waitForShutdown() {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGTERM, syscall.SIGINT)
+	sig := <-c
+	log.Infof("received %s, shutting down", sig)
+	shutdown()
+}
+
+// shutdown makes a final scale to ScaleToOnShutdown, if it is set. It first
+// cancels the root context, which stops the scale loop and releases any
+// goroutine waiting to hand it a decision, and waits for a scale already in
+// flight to finish, so that no other scale lands after the final one.
+func shutdown() {
+	autoscaler.mu.Lock()
+	// stop evaluations, triggers and operators from scaling behind the final
+	// scale
+	autoscaler.shuttingDown = true
+	autoscaler.mu.Unlock()
+	autoscaler.cancel()
+	<-autoscaler.scaleLoopDone
+
+	to := autoscaler.config().ScaleToOnShutdown
+	if to == nil || !isLeader() {
+		return
+	}
+	if autoscaler.config().DryRun {
+		log.Infof("dry run, not scaling to %d instances on shutdown", *to)
+		return
+	}
+	autoscaler.mu.Lock()
+	from := autoscaler.instances
+	autoscaler.mu.Unlock()
+	log.Infof("scaling from %d to %d instances on shutdown", from, *to)
+	updateNumInstances(scaleDecision{From: from, To: *to, Reason: "shutdown"})
+}
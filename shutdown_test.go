@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestShutdownScalesLast(t *testing.T) {
+	e := setupTest(t, 3, map[string]string{"SCALE_TO_ON_SHUTDOWN": "1"})
+	e.startScaleLoop(t)
+
+	// the scale loop has taken this decision, but may not have made the scale
+	if !sendDecision(autoscaler.scaleChan, scaleDecision{From: 3, To: 7, Reason: "load"}) {
+		t.Fatal("scale loop exited")
+	}
+	shutdown()
+	if got, want := e.render.Scales(), []int{7, 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("render scaled to %v, want %v", got, want)
+	}
+
+	rec := httptest.NewRecorder()
+	handleScale(rec, httptest.NewRequest("POST", "/scale?instances=5", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("POST /scale after shutdown: status %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+	if d, ok := triggerDecision(triggerMessage{Instances: &[]int{5}[0]}); ok {
+		t.Errorf("trigger after shutdown scaled to %d instances, want none", d.To)
+	}
+}
+
+func TestSendDecisionCancelled(t *testing.T) {
+	setupTest(t, 3, nil)
+	// a channel nothing receives from, as once the scale loop has exited
+	c := make(chan scaleDecision)
+	sent := make(chan bool)
+	go func() {
+		sent <- sendDecision(c, scaleDecision{From: 3, To: 5, Reason: "load"})
+	}()
+
+	select {
+	case ok := <-sent:
+		t.Fatalf("sendDecision() = %v with no receiver, want it blocked until cancelled", ok)
+	case <-time.After(50 * time.Millisecond):
+	}
+	autoscaler.cancel()
+	select {
+	case ok := <-sent:
+		if ok {
+			t.Error("sendDecision() = true after cancellation, want false")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("sendDecision() still blocked after cancellation")
+	}
+}
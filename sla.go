@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"math"
+)
+
+// queueSLALoad sizes the service for the most demanding queue's target wait.
+// A queue needs enough workers to keep up with its estimated arrival rate and
+// also work through its current depth within its target wait:
+//
+//	workers = arrival rate * job duration + depth * job duration / target wait
+//
+// The load is the largest requirement across queues, but never less than the
+// active job count, expressed as a job count like the other strategies.
+func queueSLALoad(ctx context.Context) (int, error) {
+	active, err := countActiveJobs(ctx)
+	if err != nil {
+		return 0, err
+	}
+	depths, err := queueDepths(ctx)
+	if err != nil {
+		return 0, err
+	}
+	perInstance := workersPerInstance()
+	required := float64(active)
+	for queue, wait := range autoscaler.config().QueueTargetWaits {
+		duration := autoscaler.config().AvgJobDuration
+		if d, ok := autoscaler.config().QueueJobDurations[queue]; ok {
+			duration = d
+		}
+		workers := autoscaler.config().QueueArrivalRates[queue]*duration.Seconds() +
+			float64(depths[queue])*duration.Seconds()/wait.Seconds()
+		queueRequiredInstances.WithLabelValues(queue).Set(math.Ceil(workers / perInstance))
+		required = math.Max(required, workers)
+	}
+	return clampBacklog(int64(math.Ceil(required))), nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// refreshInstanceCount polls Render for the instance count once the last
+// confirmed count is older than MaxInstanceCountAge, adopting the
+// reported count unless in dry-run mode. It must not be called with the state
+// mutex held.
+func refreshInstanceCount(ctx context.Context) {
+	staleness := autoscaler.config().MaxInstanceCountAge
+	if staleness <= 0 {
+		return
+	}
+	autoscaler.mu.Lock()
+	stale := autoscaler.clock.Now().Sub(autoscaler.instanceCountTime) > staleness
+	autoscaler.mu.Unlock()
+	if !stale {
+		return
+	}
+
+	actual, err := fetchInstanceCount(ctx)
+	if err != nil {
+		recordError("render", "failed to refresh stale instance count: %v", err)
+		return
+	}
+	autoscaler.mu.Lock()
+	defer autoscaler.mu.Unlock()
+	if actual != autoscaler.instances && !autoscaler.config().DryRun {
+		log.Infof("render reports %d instances, adopting it in place of the stale count of %d", actual, autoscaler.instances)
+		autoscaler.instances = actual
+	}
+}
+
+// instanceCountStale reports whether the last confirmed instance count is
+// older than MaxInstanceCountAge, logging when that starts and stops
+// being the case. It must be called with the state mutex held.
+func instanceCountStale() bool {
+	staleness := autoscaler.config().MaxInstanceCountAge
+	age := autoscaler.clock.Now().Sub(autoscaler.instanceCountTime)
+	stale := staleness > 0 && age > staleness
+	if stale && !autoscaler.countStale {
+		log.Warnf("instance count was last confirmed %s ago, holding at %d instances until render can be polled",
+			age.Round(time.Second), autoscaler.instances)
+	} else if !stale && autoscaler.countStale {
+		log.Info("instance count confirmed, resuming scaling")
+	}
+	autoscaler.countStale = stale
+	return stale
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestInstanceCountStaleness(t *testing.T) {
+	e := setupTest(t, 2, map[string]string{"MAX_INSTANCE_COUNT_AGE": "5m"})
+	autoscaler.instanceCountTime = e.clock.Now()
+
+	stale := func() bool {
+		autoscaler.mu.Lock()
+		defer autoscaler.mu.Unlock()
+		return instanceCountStale()
+	}
+	e.clock.Advance(4 * time.Minute)
+	if stale() {
+		t.Error("count confirmed 4m ago is stale, want fresh with a 5m maximum age")
+	}
+	e.clock.Advance(2 * time.Minute)
+	if !stale() {
+		t.Error("count confirmed 6m ago is fresh, want stale with a 5m maximum age")
+	}
+
+	e.render.mu.Lock()
+	e.render.instances = 3
+	e.render.mu.Unlock()
+	refreshInstanceCount(context.Background())
+	if stale() {
+		t.Error("count is still stale after polling render")
+	}
+	if autoscaler.instances != 3 {
+		t.Errorf("instances = %d after polling render, want the 3 it reports", autoscaler.instances)
+	}
+}
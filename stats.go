@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+var statsClient = &http.Client{Timeout: 10 * time.Second}
+
+// fetchStat reads a single counter from the resque-web style JSON document
+// at StatsURL.
+func fetchStat(ctx context.Context, path string) (int64, error) {
+	body, err := statsDocument(ctx)
+	if err != nil {
+		return 0, err
+	}
+	value := gjson.GetBytes(body, path)
+	if value.Type != gjson.Number {
+		return 0, fmt.Errorf("no number at %q", path)
+	}
+	return value.Int(), nil
+}
+
+// beginStatsSnapshot makes the counters read until endStatsSnapshot share one
+// fetch of the stats document, so that a measurement reads them all from the
+// same snapshot.
+func beginStatsSnapshot() {
+	autoscaler.mu.Lock()
+	autoscaler.statsSnapshotting, autoscaler.statsSnapshot = true, nil
+	autoscaler.mu.Unlock()
+}
+
+func endStatsSnapshot() {
+	autoscaler.mu.Lock()
+	autoscaler.statsSnapshotting, autoscaler.statsSnapshot = false, nil
+	autoscaler.mu.Unlock()
+}
+
+// statsDocument returns the JSON document at StatsURL, fetching it only once
+// between beginStatsSnapshot and endStatsSnapshot.
+func statsDocument(ctx context.Context) ([]byte, error) {
+	autoscaler.mu.Lock()
+	body := autoscaler.statsSnapshot
+	autoscaler.mu.Unlock()
+	if body != nil {
+		return body, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", autoscaler.config().StatsURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := statsClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	body, err = ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d", res.StatusCode)
+	}
+	autoscaler.mu.Lock()
+	if autoscaler.statsSnapshotting {
+		autoscaler.statsSnapshot = body
+	}
+	autoscaler.mu.Unlock()
+	return body, nil
+}
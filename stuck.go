@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/tidwall/gjson"
+)
+
+// refreshStuckWorkers counts the workers, across namespaces, that have been
+// processing their current job, according to its run_at, for longer than
+// StuckJobThreshold.
+// It must not be called with the state mutex held.
+func refreshStuckWorkers(ctx context.Context) {
+	threshold := autoscaler.config().StuckJobThreshold
+	if threshold <= 0 || autoscaler.redis == nil {
+		return
+	}
+	registered, err := registeredWorkers(ctx)
+	if err != nil {
+		recordError("redis", "failed to retrieve resque worker set from redis: %v", err)
+		return
+	}
+	var workerKeys []string
+	for namespace, workers := range registered {
+		for _, worker := range workers {
+			workerKeys = append(workerKeys, namespacedKey(namespace, "worker:"+worker))
+		}
+	}
+	stuck := 0
+	for start := 0; start < len(workerKeys); start += existsBatchSize {
+		end := start + existsBatchSize
+		if end > len(workerKeys) {
+			end = len(workerKeys)
+		}
+		jobs, err := autoscaler.redis.MGet(ctx, workerKeys[start:end]...).Result()
+		if err != nil {
+			recordError("redis", "failed to retrieve resque worker jobs from redis: %v", err)
+			return
+		}
+		for _, job := range jobs {
+			payload, ok := job.(string)
+			if !ok {
+				// the worker is idle
+				continue
+			}
+			runAt, ok := parseTimestamp(gjson.Get(payload, "run_at"))
+			if ok && time.Since(runAt) > threshold {
+				stuck++
+			}
+		}
+	}
+
+	autoscaler.mu.Lock()
+	defer autoscaler.mu.Unlock()
+	if stuck > 0 && autoscaler.stuckWorkers == 0 {
+		sendAlert("%d workers have been processing the same job for over %s, suppressing scale-downs", stuck, threshold)
+	} else if stuck == 0 && autoscaler.stuckWorkers > 0 {
+		log.Info("no workers are stuck anymore, resuming scale-downs")
+	}
+	autoscaler.stuckWorkers = stuck
+	stuckWorkers.Set(float64(stuck))
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestRefreshStuckWorkersNamespaces(t *testing.T) {
+	e := setupTest(t, 2, map[string]string{
+		"STUCK_JOB_THRESHOLD": "1h",
+		"RESQUE_NAMESPACES":   "app1,app2",
+	})
+	for namespace, runAt := range map[string]time.Time{
+		"app1": time.Now(),
+		"app2": time.Now().Add(-2 * time.Hour),
+	} {
+		worker := namespace + "-host:1:default"
+		if _, err := e.redis.SetAdd(namespace+":workers", worker); err != nil {
+			t.Fatal(err)
+		}
+		job := fmt.Sprintf(`{"queue":"default","run_at":%q}`, runAt.Format(time.RFC3339))
+		if err := e.redis.Set(namespace+":worker:"+worker, job); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	refreshStuckWorkers(context.Background())
+	if autoscaler.stuckWorkers != 1 {
+		t.Errorf("stuckWorkers = %d, want the 1 stuck in the second namespace", autoscaler.stuckWorkers)
+	}
+}
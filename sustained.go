@@ -0,0 +1,36 @@
+package main
+
+// trackSustainedQueues counts, per queue, how many consecutive samples the
+// queue has been non-empty, and sets transientJobs to the jobs in queues that
+// haven't been non-empty for their required number of samples yet. It must be
+// called with the state mutex held.
+func trackSustainedQueues(depths map[string]int64) {
+	if autoscaler.config().MinNonEmptySamples <= 0 && len(autoscaler.config().QueueNonEmptySamples) == 0 {
+		return
+	}
+	if autoscaler.nonEmptySamples == nil {
+		autoscaler.nonEmptySamples = make(map[string]int)
+	}
+	var transient int64
+	for queue, depth := range depths {
+		if depth <= 0 {
+			delete(autoscaler.nonEmptySamples, queue)
+			continue
+		}
+		autoscaler.nonEmptySamples[queue]++
+		required, ok := autoscaler.config().QueueNonEmptySamples[queue]
+		if !ok {
+			required = autoscaler.config().MinNonEmptySamples
+		}
+		if autoscaler.nonEmptySamples[queue] < required {
+			transient += depth
+		}
+	}
+	// forget queues that were removed from the queue set
+	for queue := range autoscaler.nonEmptySamples {
+		if _, ok := depths[queue]; !ok {
+			delete(autoscaler.nonEmptySamples, queue)
+		}
+	}
+	autoscaler.transientJobs = transient
+}
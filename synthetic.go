@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// syntheticLoad generates a job count from the time since startup, standing
+// in for the measured load when testing the scaling loop.
+type syntheticLoad struct {
+	spec string
+	// sine wave between min and max
+	min, max float64
+	period   time.Duration
+	// sequence of job counts, each held for step, repeating
+	step  time.Duration
+	steps []int
+}
+
+// parseSyntheticLoad parses either "sine:min:max:period", e.g.
+// "sine:0:500:30m", or "sequence:step:n,n,...", e.g. "sequence:5m:0,200,50".
+func parseSyntheticLoad(spec string) (*syntheticLoad, error) {
+	parts := strings.Split(spec, ":")
+	load := &syntheticLoad{spec: spec}
+	switch {
+	case parts[0] == "sine" && len(parts) == 4:
+		min, minErr := strconv.ParseFloat(parts[1], 64)
+		max, maxErr := strconv.ParseFloat(parts[2], 64)
+		period, periodErr := time.ParseDuration(parts[3])
+		if minErr != nil || maxErr != nil || periodErr != nil || min < 0 || max < min || period <= 0 {
+			return nil, fmt.Errorf("invalid synthetic load %q", spec)
+		}
+		load.min, load.max, load.period = min, max, period
+	case parts[0] == "sequence" && len(parts) == 3:
+		step, err := time.ParseDuration(parts[1])
+		if err != nil || step <= 0 {
+			return nil, fmt.Errorf("invalid synthetic load %q", spec)
+		}
+		for _, s := range strings.Split(parts[2], ",") {
+			n, err := strconv.Atoi(s)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("invalid synthetic load %q", spec)
+			}
+			load.steps = append(load.steps, n)
+		}
+		load.step = step
+	default:
+		return nil, fmt.Errorf("invalid synthetic load %q", spec)
+	}
+	return load, nil
+}
+
+// At returns the job count elapsed after startup.
+func (l *syntheticLoad) At(elapsed time.Duration) int {
+	if l.steps != nil {
+		return l.steps[int(elapsed/l.step)%len(l.steps)]
+	}
+	phase := 2 * math.Pi * float64(elapsed%l.period) / float64(l.period)
+	// start at the minimum, peaking halfway through the period
+	return int(math.Round(l.min + (l.max-l.min)*(1-math.Cos(phase))/2))
+}
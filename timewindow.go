@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// timeWindow is a daily window in a given timezone, optionally restricted to
+// certain days of the week. If end is before start the window wraps past
+// midnight.
+type timeWindow struct {
+	start    time.Duration
+	end      time.Duration
+	location *time.Location
+	days     map[time.Weekday]bool
+}
+
+func parseTimeWindow(start, end, timezone string, days []string) (*timeWindow, error) {
+	startOffset, err := parseTimeOfDay(start)
+	if err != nil {
+		return nil, fmt.Errorf("invalid start: %v", err)
+	}
+	endOffset, err := parseTimeOfDay(end)
+	if err != nil {
+		return nil, fmt.Errorf("invalid end: %v", err)
+	}
+	location, err := time.LoadLocation(timezone)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timezone: %v", err)
+	}
+	weekdays, err := parseWeekdays(days)
+	if err != nil {
+		return nil, fmt.Errorf("invalid days: %v", err)
+	}
+	return &timeWindow{start: startOffset, end: endOffset, location: location, days: weekdays}, nil
+}
+
+// parseTimeOfDay parses "15:04" into the offset from midnight.
+func parseTimeOfDay(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// parseWeekdays parses day names such as "mon" or "Monday". An empty list
+// means every day.
+func parseWeekdays(names []string) (map[time.Weekday]bool, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	days := make(map[time.Weekday]bool, len(names))
+	for _, name := range names {
+		found := false
+		for d := time.Sunday; d <= time.Saturday; d++ {
+			if len(name) >= 3 && strings.HasPrefix(strings.ToLower(d.String()), strings.ToLower(name)) {
+				days[d] = true
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown day %q", name)
+		}
+	}
+	return days, nil
+}
+
+// Contains reports whether t falls within the window.
+func (w *timeWindow) Contains(t time.Time) bool {
+	t = t.In(w.location)
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, w.location)
+	offset := t.Sub(midnight)
+	day := t.Weekday()
+	if w.start <= w.end {
+		return w.onDay(day) && offset >= w.start && offset < w.end
+	}
+	// the window wraps past midnight, so the early hours belong to the
+	// previous day's window
+	if offset >= w.start {
+		return w.onDay(day)
+	}
+	return offset < w.end && w.onDay((day+6)%7)
+}
+
+func (w *timeWindow) onDay(d time.Weekday) bool {
+	return w.days == nil || w.days[d]
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// triggerMessage is a pre-scale hint published on TriggerChannel. Exactly one
+// of its fields must be set.
+type triggerMessage struct {
+	// Instances is an instance count to scale up to.
+	Instances *int `json:"instances"`
+	// Jobs is a number of jobs about to be enqueued, on top of the current
+	// load.
+	Jobs *int `json:"jobs"`
+}
+
+// triggerLoop subscribes to TriggerChannel and scales up as soon as a hint
+// arrives, ahead of the backlog appearing. Hints never scale down, and the
+// next evaluations take over as usual.
+func triggerLoop(c chan scaleDecision) {
+	sub := autoscaler.redis.Subscribe(autoscaler.ctx, autoscaler.config().TriggerChannel)
+	log.Infof("listening for scale triggers on %s", autoscaler.config().TriggerChannel)
+	for msg := range sub.Channel() {
+		var trigger triggerMessage
+		if err := json.Unmarshal([]byte(msg.Payload), &trigger); err != nil ||
+			(trigger.Instances == nil) == (trigger.Jobs == nil) {
+			log.Warnf("ignoring malformed scale trigger %q", msg.Payload)
+			continue
+		}
+		if !isLeader() {
+			continue
+		}
+		if d, ok := triggerDecision(trigger); ok {
+			log.Infof("scale trigger received, scaling up from %d to %d instances", d.From, d.To)
+			sendDecision(c, d)
+		}
+	}
+}
+
+// triggerDecision returns the scale-up called for by a trigger, clamped to
+// the bounds and rounded to the allowed instance counts, and records it as the new instance count. The scale-up delay
+// doesn't apply, but the gates that protect against runaway or unsafe
+// scale-ups do, and the scale-up counts towards MaxConsecutiveScaleUps.
+func triggerDecision(trigger triggerMessage) (scaleDecision, bool) {
+	autoscaler.mu.Lock()
+	defer autoscaler.mu.Unlock()
+	var target int
+	if trigger.Instances != nil {
+		target = *trigger.Instances
+	} else {
+		jobs := autoscaler.lastAverage + float64(*trigger.Jobs)
+		target = autoscaler.config().BaseInstances + int(math.Ceil(jobs/workersPerInstance()))
+	}
+	now := autoscaler.clock.Now()
+	max := maxInstances()
+	if target > max {
+		target = max
+	}
+	target = allowedInstanceCount(target, minInstances(now), max)
+	if target <= autoscaler.instances || autoscaler.shuttingDown {
+		return scaleDecision{}, false
+	}
+	if observeOnly(now) {
+		log.Infof("observe only, not scaling up from %d to %d instances on trigger", autoscaler.instances, target)
+		return scaleDecision{}, false
+	}
+	var gate string
+	switch {
+	case now.Before(autoscaler.startTime.Add(autoscaler.config().StartupGracePeriod)):
+		gate = "startup-grace"
+	case autoscaler.deploying:
+		gate = "deploy"
+	default:
+		gate = scaleUpSafetyGate()
+	}
+	if gate != "" {
+		log.Infof("not scaling up from %d to %d instances on trigger (%s)", autoscaler.instances, target, gate)
+		gateBlocked.WithLabelValues(gate).Inc()
+		return scaleDecision{}, false
+	}
+	d := scaleDecision{From: autoscaler.instances, To: target, Reason: "trigger"}
+	autoscaler.instances = target
+	autoscaler.lastScaleTime = now
+	recordScaleUp(now)
+	return d, true
+}
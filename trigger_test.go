@@ -0,0 +1,43 @@
+package main
+
+import "testing"
+
+func TestTriggerRespectsScaleUpFreeze(t *testing.T) {
+	setupTest(t, 2, map[string]string{
+		"MIN_INSTANCES":             "1",
+		"MAX_CONSECUTIVE_SCALE_UPS": "2",
+	})
+	trigger := func(n int) (scaleDecision, bool) {
+		return triggerDecision(triggerMessage{Instances: &n})
+	}
+
+	if d, ok := trigger(4); !ok || d.To != 4 {
+		t.Fatalf("first trigger scaled to %d instances (ok %v), want 4", d.To, ok)
+	}
+	if d, ok := trigger(6); !ok || d.To != 6 {
+		t.Fatalf("second trigger scaled to %d instances (ok %v), want 6", d.To, ok)
+	}
+	autoscaler.mu.Lock()
+	frozen := autoscaler.scaleUpFrozen
+	autoscaler.mu.Unlock()
+	if !frozen {
+		t.Fatal("not frozen after 2 triggered scale-ups in a row, want frozen")
+	}
+	if d, ok := trigger(8); ok {
+		t.Errorf("trigger while frozen scaled to %d instances, want it ignored", d.To)
+	}
+	if autoscaler.instances != 6 {
+		t.Errorf("instances = %d after an ignored trigger, want 6", autoscaler.instances)
+	}
+}
+
+func TestTriggerHeldDuringDeploy(t *testing.T) {
+	setupTest(t, 2, map[string]string{"MIN_INSTANCES": "1"})
+	autoscaler.mu.Lock()
+	autoscaler.deploying = true
+	autoscaler.mu.Unlock()
+	n := 5
+	if d, ok := triggerDecision(triggerMessage{Instances: &n}); ok {
+		t.Errorf("trigger during a deploy scaled to %d instances, want it held", d.To)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"math"
+	"strings"
+	"sync/atomic"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// refreshWorkerCapacity measures the workers each instance actually runs, as
+// the number of workers registered with resque, across its namespaces,
+// divided by the number of hosts they registered from. Instances that are
+// still booting have no workers registered, so they don't dilute the
+// average. When it can't be measured, e.g. because no workers are registered
+// yet, the static WorkersPerInstance applies instead.
+func refreshWorkerCapacity(ctx context.Context) {
+	if !autoscaler.config().LiveWorkerCapacity || autoscaler.redis == nil {
+		return
+	}
+	registered, err := registeredWorkers(ctx)
+	if err != nil {
+		recordError("redis", "failed to list registered resque workers: %v", err)
+	}
+	workers := 0
+	hosts := make(map[string]bool)
+	for _, members := range registered {
+		workers += len(members)
+		for _, worker := range members {
+			// resque worker ids are host:pid:queues
+			host, _, _ := strings.Cut(worker, ":")
+			hosts[host] = true
+		}
+	}
+
+	live := 0.0
+	if err == nil && workers > 0 {
+		live = float64(workers) / float64(len(hosts))
+	}
+	prev := math.Float64frombits(atomic.SwapUint64(&autoscaler.liveWorkersPerInstance, math.Float64bits(live)))
+	if (live == 0) != (prev == 0) {
+		if live == 0 {
+			log.Warnf("no registered workers to measure capacity from, assuming %.0f workers per instance", workersPerInstance())
+		} else {
+			log.Infof("measuring capacity from %d workers registered on %d instances", workers, len(hosts))
+		}
+	}
+	workersPerInstanceGauge.Set(workersPerInstance())
+}
+
+// workersPerInstance returns the number of workers each instance is assumed
+// to run: the live average measured by refreshWorkerCapacity when available,
+// then the value workersPerInstanceLoop read from the worker service, and
+// otherwise WorkersPerInstance. It is safe to call with or without the state
+// mutex held.
+func workersPerInstance() float64 {
+	if live := math.Float64frombits(atomic.LoadUint64(&autoscaler.liveWorkersPerInstance)); live > 0 {
+		return live
+	}
+	if synced := atomic.LoadInt64(&autoscaler.syncedWorkers); synced > 0 {
+		return float64(synced)
+	}
+	return float64(autoscaler.config().WorkersPerInstance)
+}
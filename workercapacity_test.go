@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// registerWorkers registers perHost workers on each of hosts instances in a
+// resque namespace.
+func (e *testEnv) registerWorkers(t testing.TB, namespace string, hosts, perHost int) {
+	for host := 0; host < hosts; host++ {
+		for pid := 0; pid < perHost; pid++ {
+			name := fmt.Sprintf("%s-host-%d:%d:default", namespace, host, pid)
+			if _, err := e.redis.SetAdd(namespace+":workers", name); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestRefreshWorkerCapacity(t *testing.T) {
+	e := setupTest(t, 3, map[string]string{
+		"LIVE_WORKER_CAPACITY": "true",
+		"WORKERS_PER_INSTANCE": "2",
+	})
+	if got := workersPerInstance(); got != 2 {
+		t.Errorf("workersPerInstance() = %v before measuring, want the static 2", got)
+	}
+
+	// readers don't take the state mutex
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			workersPerInstance()
+		}
+	}()
+	e.registerWorkers(t, "resque", 3, 4)
+	refreshWorkerCapacity(context.Background())
+	wg.Wait()
+	if got := workersPerInstance(); got != 4 {
+		t.Errorf("workersPerInstance() = %v for 12 workers on 3 instances, want 4", got)
+	}
+
+	e.setWorkers(t, 0, 0)
+	refreshWorkerCapacity(context.Background())
+	if got := workersPerInstance(); got != 2 {
+		t.Errorf("workersPerInstance() = %v with no workers registered, want the static 2", got)
+	}
+}
+
+func TestRefreshWorkerCapacityNamespaces(t *testing.T) {
+	e := setupTest(t, 2, map[string]string{
+		"LIVE_WORKER_CAPACITY": "true",
+		"RESQUE_NAMESPACES":    "app1,app2",
+	})
+	e.registerWorkers(t, "app1", 1, 3)
+	e.registerWorkers(t, "app2", 1, 5)
+	refreshWorkerCapacity(context.Background())
+	if got := workersPerInstance(); got != 4 {
+		t.Errorf("workersPerInstance() = %v for 8 workers across namespaces on 2 instances, want 4", got)
+	}
+}
+
+func TestLiveWorkerCapacityIgnoresBootingInstances(t *testing.T) {
+	e := setupTest(t, 2, map[string]string{
+		"LIVE_WORKER_CAPACITY": "true",
+		"MIN_INSTANCES":        "1",
+		"MAX_INSTANCES":        "50",
+		"SCALE_UP_DELAY":       "0s",
+	})
+	e.registerWorkers(t, "resque", 2, 4)
+	e.setQueues(t, map[string]int{"default": 40})
+
+	e.clock.Advance(time.Minute)
+	if d, applied := evaluate(context.Background(), true); d.To != 10 || !applied {
+		t.Fatalf("desired %d instances (applied %v) for 40 jobs at 4 workers per instance, want 10", d.To, applied)
+	}
+	// the new instances are still booting, so no more workers have registered
+	e.clock.Advance(time.Minute)
+	if d, _ := evaluate(context.Background(), true); d.To != 10 {
+		t.Errorf("desired %d instances while the new instances boot, want the 10 already scaled to", d.To)
+	}
+	if got := workersPerInstance(); got != 4 {
+		t.Errorf("workersPerInstance() = %v while the new instances boot, want 4", got)
+	}
+}